@@ -50,6 +50,70 @@ app.kubernetes.io/name: {{ include "keycloak-operator.name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{/*
+Image reference from an image values block (registry, repository, tag,
+digest). A digest takes precedence over the tag, and the tag defaults to
+appVersion. A repository that already starts with a registry host, as in
+chart versions before registry was split out, overrides registry.
+global.imageRegistry, when set, replaces the registry of every image.
+*/}}
+{{- define "keycloak-operator.image" -}}
+{{- $registry := .image.registry }}
+{{- $repository := .image.repository }}
+{{- $host := regexFind "^[^/]+/" $repository | trimSuffix "/" }}
+{{- if or (contains "." $host) (contains ":" $host) (eq $host "localhost") }}
+{{- $registry = $host }}
+{{- $repository = trimPrefix (printf "%s/" $host) $repository }}
+{{- end }}
+{{- with .context.Values.global.imageRegistry }}
+{{- $registry = . }}
+{{- end }}
+{{- if $registry }}
+{{- $repository = printf "%s/%s" $registry $repository }}
+{{- end }}
+{{- if .image.digest }}
+{{- printf "%s@%s" $repository .image.digest }}
+{{- else }}
+{{- printf "%s:%s" $repository (.image.tag | default .context.Chart.AppVersion) }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the Secret created from imagePullCredentials
+*/}}
+{{- define "keycloak-operator.imagePullSecretName" -}}
+{{- printf "%s-pull-secret" (include "keycloak-operator.fullname" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Pod imagePullSecrets: imagePullSecrets plus the Secret created from
+imagePullCredentials, as YAML. Empty when there are none.
+*/}}
+{{- define "keycloak-operator.imagePullSecrets" -}}
+{{- $secrets := .Values.imagePullSecrets | default list }}
+{{- if .Values.imagePullCredentials.create }}
+{{- $secrets = append $secrets (dict "name" (include "keycloak-operator.imagePullSecretName" .)) }}
+{{- end }}
+{{- with $secrets }}
+{{- toYaml . }}
+{{- end }}
+{{- end }}
+
+{{/*
+.dockerconfigjson content for imagePullCredentials. The registry defaults to
+global.imageRegistry.
+*/}}
+{{- define "keycloak-operator.dockerconfigjson" -}}
+{{- with .Values.imagePullCredentials }}
+{{- $registry := .registry | default $.Values.global.imageRegistry | required "imagePullCredentials.registry or global.imageRegistry is required" }}
+{{- $auth := dict "username" (required "imagePullCredentials.username is required" .username) "password" (required "imagePullCredentials.password is required" .password) "auth" (printf "%s:%s" .username .password | b64enc) }}
+{{- with .email }}
+{{- $_ := set $auth "email" . }}
+{{- end }}
+{{- dict "auths" (dict $registry $auth) | toJson }}
+{{- end }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
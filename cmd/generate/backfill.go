@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+
+	"github.com/px3-dev/keycloak-operator/internal/chart"
+	"github.com/px3-dev/keycloak-operator/internal/helmrepo"
+	"github.com/px3-dev/keycloak-operator/internal/semver"
+)
+
+// backfillResult is the outcome for one upstream version.
+type backfillResult struct {
+	version string
+	status  string // added, skipped or failed
+	detail  string
+}
+
+// backfillJob holds what every backfilled version shares.
+type backfillJob struct {
+	source       string
+	work         string
+	dir          string
+	url          string
+	chartVersion string
+	ignored      chart.IgnoreList
+	key          *packet.PrivateKey
+	created      time.Time
+	index        *helmrepo.Index
+}
+
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	versions := flags.String("versions", "", "comma-separated upstream versions to backfill")
+	versionRange := flags.String("range", "", `semver range of upstream versions to backfill, e.g. ">=25.0.0 <26.0.0"`)
+	source := flags.String("source", "", "directory with a <version>/ subdirectory of upstream files per release (default: fetch them from upstream)")
+	dir := flags.String("dir", ".", "directory to write the chart archives and index.yaml to")
+	url := flags.String("url", "", "base URL the chart archives are served from")
+	merge := flags.String("merge", "", "path or URL of an existing index.yaml to merge; a missing one starts a new index")
+	chartVersion := flags.String("chart-version", "0.0.{number}", "chart version of each backfilled chart; {version} is replaced by the upstream version and {number} by it as one number, major*10000+minor*100+patch")
+	work := flags.String("work", "", "directory to generate each chart in, as <work>/<version> (default: a temporary directory)")
+	ignore := flags.String("ignore", "", "file listing acknowledged drops, one <Kind/name> <path> per line")
+	keyFile := flags.String("signing-key", "", "OpenPGP private key to write .prov provenance files with (default: the SIGNING_KEY environment variable, if set)")
+	flags.Parse(args)
+
+	if *url == "" {
+		fmt.Fprintln(os.Stderr, "error: --url is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *versions == "" && *versionRange == "" {
+		fmt.Fprintln(os.Stderr, "error: --versions or --range is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	candidates, err := backfillVersions(*versions, *versionRange, *source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no upstream versions to backfill")
+		os.Exit(1)
+	}
+
+	job := backfillJob{
+		source:       *source,
+		work:         *work,
+		dir:          *dir,
+		url:          *url,
+		chartVersion: *chartVersion,
+		index:        helmrepo.NewIndex(),
+	}
+	if *ignore != "" {
+		if job.ignored, err = chart.LoadIgnore(*ignore); err != nil {
+			fmt.Fprintf(os.Stderr, "error reading ignore file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if job.key, err = signingKey(*keyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading signing key: %v\n", err)
+		os.Exit(1)
+	}
+	if job.created, err = timestamp(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if *merge != "" {
+		existing, err := helmrepo.LoadIndex(*merge)
+		switch {
+		case errors.Is(err, helmrepo.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "No index at %s, creating a new one\n", *merge)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "error reading index: %v\n", err)
+			os.Exit(1)
+		default:
+			job.index = existing
+		}
+	}
+	if job.work == "" {
+		tmp, err := os.MkdirTemp("", "backfill-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmp)
+		job.work = tmp
+	}
+
+	var results []backfillResult
+	added := 0
+	for _, v := range candidates {
+		r := job.run(v)
+		if r.status == "added" {
+			added++
+		}
+		results = append(results, r)
+	}
+
+	out := filepath.Join(*dir, "index.yaml")
+	if added > 0 {
+		if err := job.index.Write(out, job.created); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing index: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.version, r.status, r.detail)
+	}
+	w.Flush()
+	fmt.Printf("%d added, %d skipped, %d failed\n", counts["added"], counts["skipped"], counts["failed"])
+	if added > 0 {
+		fmt.Printf("Wrote %s\n", out)
+	}
+}
+
+// backfillVersions returns the listed versions plus the upstream releases in
+// versionRange, oldest first. Releases are the subdirectories of source, or
+// the upstream tags without one.
+func backfillVersions(list, versionRange, source string) ([]string, error) {
+	seen := make(map[string]bool)
+	var versions []string
+	add := func(v string) {
+		if !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			add(v)
+		}
+	}
+
+	if versionRange != "" {
+		r, err := semver.ParseRange(versionRange)
+		if err != nil {
+			return nil, err
+		}
+		releases, err := upstreamReleases(source)
+		if err != nil {
+			return nil, fmt.Errorf("listing upstream releases: %w", err)
+		}
+		for _, rel := range releases {
+			if v, err := semver.Parse(rel); err == nil && r.Contains(v) {
+				add(rel)
+			}
+		}
+	}
+
+	// Versions that do not parse sort last; they fail later with a reason.
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, aErr := semver.Parse(versions[i])
+		b, bErr := semver.Parse(versions[j])
+		if aErr != nil || bErr != nil {
+			return aErr == nil
+		}
+		return a.Compare(b) < 0
+	})
+	return versions, nil
+}
+
+func upstreamReleases(source string) ([]string, error) {
+	if source == "" {
+		return chart.UpstreamReleases()
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	var releases []string
+	for _, e := range entries {
+		if e.IsDir() {
+			releases = append(releases, e.Name())
+		}
+	}
+	return releases, nil
+}
+
+// backfillChartVersion returns the chart version pattern gives an upstream
+// version. {number} packs the version into one patch number, so that the
+// default 0.0.{number} sorts below every regular release, keeps upstream
+// order and, unlike a pre-release, is shown by Helm without --devel.
+func backfillChartVersion(pattern, version string) (string, error) {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(pattern, "{number}") {
+		if v.Pre != "" || v.Minor >= 100 || v.Patch >= 100 {
+			return "", fmt.Errorf("upstream version %s cannot be packed into {number}", version)
+		}
+		pattern = strings.ReplaceAll(pattern, "{number}", strconv.Itoa(v.Major*10000+v.Minor*100+v.Patch))
+	}
+	chartVersion := strings.ReplaceAll(pattern, "{version}", version)
+	if _, err := semver.Parse(chartVersion); err != nil {
+		return "", err
+	}
+	return chartVersion, nil
+}
+
+// run generates, packages and indexes the chart of one upstream version.
+func (j *backfillJob) run(version string) backfillResult {
+	r := backfillResult{version: version, status: "failed"}
+	chartVersion, err := backfillChartVersion(j.chartVersion, version)
+	if err != nil {
+		r.detail = fmt.Sprintf("chart version: %v", err)
+		return r
+	}
+	if _, ok := j.index.Lookup(chart.Name, "version", chartVersion); ok {
+		r.status, r.detail = "skipped", fmt.Sprintf("chart %s is already in the index", chartVersion)
+		return r
+	}
+	if e, ok := j.index.Lookup(chart.Name, "appVersion", version); ok {
+		r.status, r.detail = "skipped", fmt.Sprintf("already released as chart %v", e["version"])
+		return r
+	}
+
+	manifest, crds := "", []string(nil)
+	if j.source != "" {
+		manifest = filepath.Join(j.source, version, chart.UpstreamManifest)
+		for _, name := range chart.UpstreamCRDs {
+			crds = append(crds, filepath.Join(j.source, version, name))
+		}
+	}
+	src, crdSources, err := readInputs(manifest, crds, version)
+	if err != nil {
+		r.detail = fmt.Sprintf("reading inputs: %v", err)
+		return r
+	}
+	// A release the generator cannot turn into a chart is skipped with the
+	// reason; only I/O, packaging and indexing errors fail it.
+	upstream, err := chart.Parse(src.Data, version, j.ignored)
+	if err != nil {
+		r.status, r.detail = "skipped", fmt.Sprintf("parsing manifest: %v", err)
+		return r
+	}
+
+	out := filepath.Join(j.work, version)
+	if err := os.RemoveAll(out); err != nil {
+		r.detail = err.Error()
+		return r
+	}
+	if err := chart.Generate(upstream, out, chart.Options{CRDs: crdSources, ChartVersion: chartVersion}); err != nil {
+		r.status, r.detail = "skipped", fmt.Sprintf("generating chart: %v", err)
+		return r
+	}
+	tgz, err := helmrepo.Package(out, j.dir)
+	if err != nil {
+		r.detail = fmt.Sprintf("packaging chart: %v", err)
+		return r
+	}
+	if j.key != nil {
+		if _, err := helmrepo.Sign(tgz, j.key); err != nil {
+			r.detail = fmt.Sprintf("signing chart: %v", err)
+			return r
+		}
+	}
+	if err := j.index.Add(tgz, j.url, j.created); err != nil {
+		r.detail = fmt.Sprintf("indexing chart: %v", err)
+		return r
+	}
+
+	r.status, r.detail = "added", filepath.Base(tgz)
+	if drops := j.ignored.Unacknowledged(upstream.Drops); len(drops) > 0 {
+		r.detail += fmt.Sprintf(" (%d upstream fields dropped)", len(drops))
+	}
+	return r
+}
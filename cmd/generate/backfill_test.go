@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBackfillChartVersion(t *testing.T) {
+	tests := []struct {
+		pattern, version string
+		want             string // empty for an error
+	}{
+		{"0.0.{number}", "25.0.6", "0.0.250006"},
+		{"0.0.{number}", "26.5.3", "0.0.260503"},
+		{"0.0.{number}", "26.0", "0.0.260000"},
+		{"0.0.{number}", "26.1.0-rc.1", ""},
+		{"0.0.{number}", "26.100.0", ""},
+		{"0.0.0-upstream.{version}", "25.0.6", "0.0.0-upstream.25.0.6"},
+		{"{version}", "not-a-version", ""},
+	}
+	for _, tt := range tests {
+		got, err := backfillChartVersion(tt.pattern, tt.version)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("backfillChartVersion(%q, %q) = %s, want an error", tt.pattern, tt.version, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("backfillChartVersion(%q, %q) = %s, %v, want %s", tt.pattern, tt.version, got, err, tt.want)
+		}
+	}
+
+	// Packed versions keep upstream order.
+	prev := 0
+	for _, v := range []string{"24.0.5", "25.0.6", "25.1.0", "26.0.7", "26.5.3"} {
+		got, _ := backfillChartVersion("0.0.{number}", v)
+		n, err := strconv.Atoi(strings.TrimPrefix(got, "0.0."))
+		if err != nil || n <= prev {
+			t.Errorf("%s packs to %s, not above %d", v, got, prev)
+		}
+		prev = n
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/px3-dev/keycloak-operator/internal/chart"
+)
+
+// imageEntry is an image in the JSON output of the images command.
+type imageEntry struct {
+	Name   string `json:"name"`
+	Values string `json:"values"`
+	Image  string `json:"image"`
+	Target string `json:"target,omitempty"`
+}
+
+func runImages(args []string) {
+	flags := flag.NewFlagSet("images", flag.ExitOnError)
+	chartDir := flags.String("chart", "chart", "generated chart directory")
+	format := flags.String("format", "text", "output format: text, json or skopeo (a skopeo sync --src yaml file)")
+	target := flags.String("target-registry", "", "mirror registry the images are copied to")
+	overrides := flags.String("values-output", "values-mirror.yaml", "with --target-registry, values file pointing the chart at the mirror")
+	var valueFiles stringSlice
+	flags.Var(&valueFiles, "values", "values file to apply over the chart's defaults (repeatable)")
+	flags.Parse(args)
+
+	appVersion, err := chart.ChartAppVersion(*chartDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading chart: %v\n", err)
+		os.Exit(1)
+	}
+	values, err := chart.LoadValues(*chartDir, valueFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading values: %v\n", err)
+		os.Exit(1)
+	}
+	images, err := values.ResolveImages(appVersion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error resolving images: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "text":
+		err = writeImagesText(os.Stdout, images, *target)
+	case "json":
+		err = writeImagesJSON(os.Stdout, images, *target)
+	case "skopeo":
+		err = writeSkopeoSync(os.Stdout, images)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing images: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *target != "" {
+		if err := writeMirrorValues(*overrides, *target); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing mirror values: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote values for %s to %s\n", *target, *overrides)
+	}
+}
+
+// mirrored returns the reference of img once copied into target, which is
+// where skopeo sync puts it: the repository path under the target registry.
+func mirrored(img chart.ImageRef, target string) string {
+	img.Registry = strings.TrimSuffix(target, "/")
+	return img.String()
+}
+
+func writeImagesText(out io.Writer, images []chart.ChartImage, target string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, img := range images {
+		if target != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", img.Name, img.Image, mirrored(img.Image, target))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", img.Name, img.Image)
+		}
+	}
+	return w.Flush()
+}
+
+func writeImagesJSON(w io.Writer, images []chart.ChartImage, target string) error {
+	entries := make([]imageEntry, len(images))
+	for i, img := range images {
+		entries[i] = imageEntry{Name: img.Name, Values: img.Values, Image: img.Image.String()}
+		if target != "" {
+			entries[i].Target = mirrored(img.Image, target)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// writeSkopeoSync writes the images as a skopeo sync --src yaml file, keyed
+// by source registry, with the tag or digest of each repository.
+func writeSkopeoSync(w io.Writer, images []chart.ChartImage) error {
+	type registry struct {
+		Images map[string][]string `yaml:"images"`
+	}
+	plan := map[string]*registry{}
+	for _, img := range images {
+		host, repository := img.Image.Registry, img.Image.Repository
+		if host == "" {
+			host = "docker.io"
+			if !strings.Contains(repository, "/") {
+				repository = "library/" + repository
+			}
+		}
+		version := img.Image.Tag
+		if img.Image.Digest != "" {
+			version = img.Image.Digest
+		}
+		if plan[host] == nil {
+			plan[host] = &registry{Images: map[string][]string{}}
+		}
+		versions := plan[host].Images[repository]
+		if !contains(versions, version) {
+			versions = append(versions, version)
+			sort.Strings(versions)
+		}
+		plan[host].Images[repository] = versions
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(plan); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeMirrorValues writes a values file that points every image at target.
+func writeMirrorValues(path, target string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := yaml.NewEncoder(f)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]interface{}{
+		"global": map[string]interface{}{
+			"imageRegistry": strings.TrimSuffix(target, "/"),
+		},
+	}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
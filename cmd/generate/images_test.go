@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/px3-dev/keycloak-operator/internal/chart"
+)
+
+func chartImages(t *testing.T, refs ...string) []chart.ChartImage {
+	t.Helper()
+	var images []chart.ChartImage
+	for _, s := range refs {
+		ref, err := chart.ParseImageRef(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, chart.ChartImage{Name: s, Image: ref})
+	}
+	return images
+}
+
+func TestWriteSkopeoSync(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0", 64)
+	tests := []struct {
+		name   string
+		images []string
+		want   string
+	}{
+		{
+			"grouped by registry",
+			[]string{
+				"quay.io/keycloak/keycloak-operator:26.5.3",
+				"quay.io/keycloak/keycloak@" + digest,
+				"localhost:5000/tools/kubectl:1.30",
+			},
+			"localhost:5000:\n  images:\n    tools/kubectl:\n      - \"1.30\"\n" +
+				"quay.io:\n  images:\n    keycloak/keycloak:\n      - " + digest + "\n    keycloak/keycloak-operator:\n      - 26.5.3\n",
+		},
+		{
+			"docker.io official image",
+			[]string{"busybox:1.36"},
+			"docker.io:\n  images:\n    library/busybox:\n      - \"1.36\"\n",
+		},
+		{
+			"docker.io user image",
+			[]string{"fluent/fluent-bit:3.0"},
+			"docker.io:\n  images:\n    fluent/fluent-bit:\n      - \"3.0\"\n",
+		},
+		{
+			"versions of one repository",
+			[]string{"busybox:1.37", "busybox:1.36", "busybox:1.37"},
+			"docker.io:\n  images:\n    library/busybox:\n      - \"1.36\"\n      - \"1.37\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeSkopeoSync(&buf, chartImages(t, tt.images...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMirrorValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values-mirror.yaml")
+	if err := writeMirrorValues(path, "mirror.example.com/keycloak/"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "global:\n  imageRegistry: mirror.example.com/keycloak\n"; string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMirrored(t *testing.T) {
+	images := chartImages(t, "quay.io/keycloak/keycloak-operator:26.5.3", "localhost:5000/tools/kubectl@sha256:"+strings.Repeat("0", 64))
+	for i, want := range []string{
+		"mirror.example.com/keycloak/keycloak-operator:26.5.3",
+		"mirror.example.com/tools/kubectl@sha256:" + strings.Repeat("0", 64),
+	} {
+		if got := mirrored(images[i].Image, "mirror.example.com/"); got != want {
+			t.Errorf("mirrored(%s) = %s, want %s", images[i].Image, got, want)
+		}
+	}
+}
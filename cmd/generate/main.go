@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/px3-dev/keycloak-operator/internal/chart"
+	"github.com/px3-dev/keycloak-operator/internal/oci"
 )
 
 type stringSlice []string
@@ -17,35 +19,214 @@ func (s *stringSlice) Set(v string) error {
 	return nil
 }
 
+// commands maps subcommand names to their entry points. Without a known
+// subcommand, the arguments are those of generate.
+var commands = map[string]func(args []string){
+	"generate": runGenerate,
+	"images":   runImages,
+	"package":  runPackage,
+	"index":    runIndex,
+	"push":     runPush,
+	"verify":   runVerify,
+	"backfill": runBackfill,
+}
+
 func main() {
-	manifest := flag.String("manifest", "", "path to upstream kubernetes.yml")
-	output := flag.String("output", "chart", "output directory for Helm chart")
-	var crds stringSlice
-	flag.Var(&crds, "crd", "CRD file to include (repeatable)")
-	flag.Parse()
-
-	if *manifest == "" {
-		fmt.Fprintln(os.Stderr, "error: --manifest is required")
-		flag.Usage()
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+	runGenerate(os.Args[1:])
+}
+
+func runGenerate(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	manifest := flags.String("manifest", "", "path or URL of upstream kubernetes.yml")
+	upstreamVersion := flags.String("upstream-version", "", "upstream release to fetch kubernetes.yml and the CRDs of, unless --manifest is set; also the appVersion if the operator image has no tag")
+	output := flags.String("output", "chart", "output directory for Helm chart")
+	strict := flags.Bool("strict", false, "fail if any upstream resource or field is dropped and not acknowledged")
+	ignore := flags.String("ignore", "", "file listing acknowledged drops, one <Kind/name> <path> per line")
+	chartVersion := flags.String("chart-version", "", "chart version to write to Chart.yaml (default: keep the existing one)")
+	pinDigests := flags.Bool("pin-digests", false, "resolve image tags to digests through the registries and pin them")
+	lockPath := flags.String("lock", "", "lock file recording the upstream version and input checksums, e.g. upstream.lock")
+	verify := flags.Bool("verify", false, "fail if the inputs do not match the lock file")
+	updateLock := flags.Bool("update-lock", false, "accept inputs that do not match the lock file and rewrite it")
+	splitCRDs := flags.Bool("split-crds", false, "generate the CRDs as a separate keycloak-operator-crds chart in <output>/charts, which the operator chart depends on, instead of crds/")
+	examples := flags.String("examples", "examples", "directory to write an example custom resource of every CRD to; empty to skip")
+	changelog := flags.String("changelog", "CHANGELOG.md", "changelog to prepend an entry to when the chart version changes; empty to skip")
+	var crds, endpoints, changes, signed, attested stringSlice
+	flags.Var(&crds, "crd", "path or URL of a CRD file to include (repeatable)")
+	flags.Var(&signed, "expect-signature", "image pattern (registry/repository, * as a wildcard) expected to carry a cosign signature, recorded in the SBOM (repeatable)")
+	flags.Var(&attested, "expect-attestation", "image pattern expected to carry an SBOM attestation, recorded in the SBOM (repeatable)")
+	flags.Var(&changes, "change", "chart-level change to record in artifacthub.io/changes and the changelog, as kind:description, e.g. added:Add service.extraPorts (repeatable)")
+	flags.Var(&endpoints, "registry-endpoint", "registry to reach at another URL, as host=url, e.g. quay.io=http://localhost:5000 (repeatable)")
+	flags.Parse(args)
+
+	if *manifest == "" && *upstreamVersion == "" {
+		fmt.Fprintln(os.Stderr, "error: --manifest or --upstream-version is required")
+		flags.Usage()
 		os.Exit(1)
 	}
+	if (*verify || *updateLock) && *lockPath == "" {
+		fmt.Fprintln(os.Stderr, "error: --verify and --update-lock require --lock")
+		os.Exit(1)
+	}
+
+	var chartChanges []chart.Change
+	for _, c := range changes {
+		change, err := chart.ParseChange(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		chartChanges = append(chartChanges, change)
+	}
 
-	data, err := os.ReadFile(*manifest)
+	source, crdSources, err := readInputs(*manifest, crds, *upstreamVersion)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading manifest: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error reading inputs: %v\n", err)
 		os.Exit(1)
 	}
 
-	upstream, err := chart.Parse(data)
+	var ignored chart.IgnoreList
+	if *ignore != "" {
+		ignored, err = chart.LoadIgnore(*ignore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading ignore file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	upstream, err := chart.Parse(source.Data, *upstreamVersion, ignored)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing manifest: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err := chart.Generate(upstream, *output, crds); err != nil {
+	version := *upstreamVersion
+	if version == "" {
+		version = upstream.AppVersion
+	}
+	lock := chart.NewLock(version, append([]chart.Source{source}, crdSources...)...)
+	if *verify && !*updateLock {
+		if err := verifyLock(*lockPath, lock); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	drops := ignored.Unacknowledged(upstream.Drops)
+	for _, d := range drops {
+		fmt.Fprintf(os.Stderr, "warning: dropped %s\n", d)
+	}
+	if *strict && len(drops) > 0 {
+		fmt.Fprintf(os.Stderr, "error: %d upstream resources or fields dropped (strict mode)\n", len(drops))
+		os.Exit(1)
+	}
+
+	if *pinDigests {
+		client, err := registryClient(endpoints)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		err = upstream.PinDigests(func(ref chart.ImageRef) (string, error) {
+			return client.Resolve(context.Background(), ref.Registry, ref.Repository, ref.Tag)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error pinning digests: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	released, err := timestamp()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	opts := chart.Options{CRDs: crdSources, ChartVersion: *chartVersion, SplitCRDs: *splitCRDs, Examples: *examples, Changelog: *changelog, Released: released, Changes: chartChanges, SignedImages: signed, AttestedImages: attested}
+	if *lockPath != "" {
+		opts.Lock = &lock
+	}
+	if err := chart.Generate(upstream, *output, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "error generating chart: %v\n", err)
 		os.Exit(1)
 	}
 
+	if opts.Lock != nil {
+		if err := lock.Write(*lockPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing lock file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Printf("Generated Helm chart for keycloak-operator %s in %s\n", upstream.AppVersion, *output)
 }
+
+// registryClient returns an OCI client that reaches each registry in
+// endpoints (host=url) at its URL. Credentials come from REGISTRY_USERNAME
+// and REGISTRY_PASSWORD, or REGISTRY_TOKEN.
+func registryClient(endpoints []string) (*oci.Client, error) {
+	client := &oci.Client{
+		Endpoints: make(map[string]string),
+		Username:  os.Getenv("REGISTRY_USERNAME"),
+		Password:  os.Getenv("REGISTRY_PASSWORD"),
+		Token:     os.Getenv("REGISTRY_TOKEN"),
+	}
+	for _, e := range endpoints {
+		host, url, ok := strings.Cut(e, "=")
+		if !ok || host == "" || url == "" {
+			return nil, fmt.Errorf("--registry-endpoint %q: expected host=url", e)
+		}
+		client.Endpoints[host] = url
+	}
+	return client, nil
+}
+
+// readInputs reads the upstream manifest and CRDs from paths or URLs. With no
+// manifest, the files of upstreamVersion are fetched from upstream.
+func readInputs(manifest string, crds []string, upstreamVersion string) (chart.Source, []chart.Source, error) {
+	if manifest == "" {
+		manifest = chart.UpstreamURL(upstreamVersion, chart.UpstreamManifest)
+		if len(crds) == 0 {
+			for _, name := range chart.UpstreamCRDs {
+				crds = append(crds, chart.UpstreamURL(upstreamVersion, name))
+			}
+		}
+	}
+
+	source, err := chart.ReadSource(manifest)
+	if err != nil {
+		return chart.Source{}, nil, fmt.Errorf("manifest: %w", err)
+	}
+	var crdSources []chart.Source
+	for _, c := range crds {
+		crd, err := chart.ReadSource(c)
+		if err != nil {
+			return chart.Source{}, nil, fmt.Errorf("CRD: %w", err)
+		}
+		crdSources = append(crdSources, crd)
+	}
+	return source, crdSources, nil
+}
+
+// verifyLock fails if lock does not match the lock file at path.
+func verifyLock(path string, lock chart.Lock) error {
+	locked, err := chart.LoadLock(path)
+	if err != nil {
+		return fmt.Errorf("reading lock file: %w", err)
+	}
+	if locked == nil {
+		return fmt.Errorf("%s does not exist; create it with --update-lock", path)
+	}
+	mismatches := locked.Mismatches(lock)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	for _, m := range mismatches {
+		fmt.Fprintf(os.Stderr, "mismatch: %s\n", m)
+	}
+	return fmt.Errorf("inputs do not match %s; rerun with --update-lock to accept them", path)
+}
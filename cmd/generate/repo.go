@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+
+	"github.com/px3-dev/keycloak-operator/internal/chart"
+	"github.com/px3-dev/keycloak-operator/internal/helmrepo"
+)
+
+func runPackage(args []string) {
+	flags := flag.NewFlagSet("package", flag.ExitOnError)
+	chartDir := flags.String("chart", "chart", "chart directory")
+	dest := flags.String("destination", ".", "directory to write the chart archive to")
+	keyFile := flags.String("signing-key", "", "OpenPGP private key to write a .prov provenance file with (default: the SIGNING_KEY environment variable, if set)")
+	flags.Parse(args)
+
+	key, err := signingKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading signing key: %v\n", err)
+		os.Exit(1)
+	}
+
+	// A CRD chart generated with --split-crds is also published on its own,
+	// for admins who install the CRDs separately.
+	charts := []string{*chartDir}
+	crdChart := filepath.Join(*chartDir, "charts", chart.CRDChartName)
+	if _, err := os.Stat(filepath.Join(crdChart, "Chart.yaml")); err == nil {
+		charts = append(charts, crdChart)
+	}
+
+	for _, dir := range charts {
+		path, err := helmrepo.Package(dir, *dest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error packaging chart: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Packaged %s\n", path)
+
+		if key == nil {
+			continue
+		}
+		prov, err := helmrepo.Sign(path, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error signing chart: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Signed %s\n", prov)
+	}
+}
+
+// signingKey reads the key to sign charts with from file or, without one,
+// from the SIGNING_KEY environment variable. A protected key is unlocked
+// with SIGNING_KEY_PASSPHRASE. It returns nil if no key is configured.
+func signingKey(file string) (*packet.PrivateKey, error) {
+	var data []byte
+	switch {
+	case file != "":
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	case os.Getenv("SIGNING_KEY") != "":
+		data = []byte(os.Getenv("SIGNING_KEY"))
+	default:
+		return nil, nil
+	}
+	keys, err := helmrepo.ReadKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	return helmrepo.SigningKey(keys, []byte(os.Getenv("SIGNING_KEY_PASSPHRASE")))
+}
+
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyring := flags.String("keyring", "", "public keyring to check the signature against, armored or binary")
+	prov := flags.String("prov", "", "provenance file (default <chart.tgz>.prov)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: generate verify [flags] <chart.tgz>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyring == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	tgz := flags.Arg(0)
+	if *prov == "" {
+		*prov = tgz + ".prov"
+	}
+
+	data, err := os.ReadFile(*keyring)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading keyring: %v\n", err)
+		os.Exit(1)
+	}
+	keys, err := helmrepo.ReadKeyRing(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading keyring: %v\n", err)
+		os.Exit(1)
+	}
+
+	v, err := helmrepo.Verify(tgz, *prov, keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error verifying chart: %v\n", err)
+		os.Exit(1)
+	}
+	var names []string
+	for name := range v.Signer.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Signed by: %s\n", name)
+	}
+	fmt.Printf("Using Key With Fingerprint: %X\n", v.Signer.PrimaryKey.Fingerprint)
+	fmt.Printf("Chart Hash Verified: %s\n", v.FileHash)
+}
+
+func runIndex(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory with the chart archives; index.yaml is written there")
+	url := flags.String("url", "", "base URL the chart archives are served from")
+	merge := flags.String("merge", "", "path or URL of an existing index.yaml to merge; a missing one starts a new index")
+	page := flags.String("page", "", "html/template of the landing page to render from the index as index.html, e.g. pages/index.html")
+	ociRepo := flags.String("oci-repo", "", "OCI repository the charts are also pushed to, as oci://<registry>/<namespace>, for the landing page")
+	artifactHub := flags.Bool("artifacthub", false, "also write artifacthub-repo.yml, with the repository ID from ARTIFACTHUB_REPOSITORY_ID")
+	flags.Parse(args)
+
+	if *url == "" {
+		fmt.Fprintln(os.Stderr, "error: --url is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	now, err := timestamp()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	archives, err := filepath.Glob(filepath.Join(*dir, "*.tgz"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error listing chart archives: %v\n", err)
+		os.Exit(1)
+	}
+	sort.Strings(archives)
+
+	idx := helmrepo.NewIndex()
+	for _, a := range archives {
+		if err := idx.Add(a, *url, now); err != nil {
+			fmt.Fprintf(os.Stderr, "error indexing %s: %v\n", a, err)
+			os.Exit(1)
+		}
+	}
+
+	if *merge != "" {
+		existing, err := helmrepo.LoadIndex(*merge)
+		switch {
+		case errors.Is(err, helmrepo.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "No index at %s, creating a new one\n", *merge)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "error reading index: %v\n", err)
+			os.Exit(1)
+		default:
+			idx.Merge(existing)
+		}
+	}
+
+	out := filepath.Join(*dir, "index.yaml")
+	if err := idx.Write(out, now); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing index: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", out)
+
+	if *artifactHub {
+		repoFile := filepath.Join(*dir, helmrepo.ArtifactHubRepoFile)
+		repo := idx.ArtifactHubRepo(chart.Name, os.Getenv("ARTIFACTHUB_REPOSITORY_ID"))
+		if err := repo.Write(repoFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %s: %v\n", repoFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", repoFile)
+	}
+
+	if *page == "" {
+		return
+	}
+	html := filepath.Join(*dir, "index.html")
+	if err := helmrepo.RenderPage(*page, html, idx.Page(chart.Name, *url, *ociRepo)); err != nil {
+		fmt.Fprintf(os.Stderr, "error rendering landing page: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", html)
+}
+
+// timestamp returns the time to record as created and generated:
+// SOURCE_DATE_EPOCH if set, so that rebuilding a release gives the same
+// index, or the current time.
+func timestamp() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now(), nil
+	}
+	n, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH: %w", err)
+	}
+	return time.Unix(n, 0), nil
+}
+
+func runPush(args []string) {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	var endpoints stringSlice
+	flags.Var(&endpoints, "registry-endpoint", "registry to reach at another URL, as host=url, e.g. registry.local=http://localhost:5000 (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: generate push [flags] <chart.tgz> oci://<registry>/<namespace>")
+		fmt.Fprintln(flags.Output(), "Credentials come from REGISTRY_USERNAME and REGISTRY_PASSWORD, or REGISTRY_TOKEN.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	client, err := registryClient(endpoints)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	now, err := timestamp()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ref, digest, err := helmrepo.Push(context.Background(), client, flags.Arg(0), flags.Arg(1), now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error pushing chart: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pushed %s\nDigest: %s\n", ref, digest)
+}
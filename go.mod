@@ -2,4 +2,13 @@ module github.com/px3-dev/keycloak-operator
 
 go 1.24.13
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cloudflare/circl v1.6.1 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
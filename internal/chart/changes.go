@@ -0,0 +1,613 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Change is an entry of the artifacthub.io/changes annotation. Kind is one
+// of added, changed, deprecated, removed or security.
+type Change struct {
+	Kind        string `yaml:"kind"`
+	Description string `yaml:"description"`
+}
+
+// changeKinds orders changes in the annotation and the changelog.
+var changeKinds = []string{"added", "changed", "deprecated", "removed", "security"}
+
+// ParseChange parses a change given as kind:description, e.g.
+// "added:Add the service.extraPorts value".
+func ParseChange(s string) (Change, error) {
+	kind, desc, ok := strings.Cut(s, ":")
+	kind, desc = strings.TrimSpace(kind), strings.TrimSpace(desc)
+	if !ok || desc == "" {
+		return Change{}, fmt.Errorf("change %q: expected kind:description", s)
+	}
+	if kindRank(kind) == len(changeKinds) {
+		return Change{}, fmt.Errorf("change %q: kind must be one of %s", s, strings.Join(changeKinds, ", "))
+	}
+	return Change{Kind: kind, Description: desc}, nil
+}
+
+// addChanges appends the changes not yet in list and orders the result by
+// kind.
+func addChanges(list []Change, changes []Change) []Change {
+	out := append([]Change(nil), list...)
+	for _, c := range changes {
+		seen := false
+		for _, o := range out {
+			if o == c {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return kindRank(out[i].Kind) < kindRank(out[j].Kind)
+	})
+	return out
+}
+
+// chartState is what a generated chart directory says about the upstream
+// content the chart deploys. Generate compares the state before and after
+// rendering to describe a release.
+type chartState struct {
+	Version    string
+	AppVersion string
+	Changes    []Change // the chart's current artifacthub.io/changes
+
+	Images map[string]string // inventory name -> image
+	Env    map[string]string // "<container> <VAR>" -> value
+	Probes map[string]string // "<container> <probe>" -> probe as JSON
+	Rules  map[string]bool   // "<Kind> <name>: <apiGroup>/<resource> <verb>"
+	CRDs   map[string]crdState
+}
+
+type crdState struct {
+	Kind     string
+	Versions map[string]crdVersionState
+}
+
+type crdVersionState struct {
+	Deprecated bool
+	Schema     string          // openAPIV3Schema as JSON
+	Fields     map[string]bool // dotted property paths
+}
+
+// helmAction matches a Helm template action, with or without trim markers.
+// An action, such as a comment, may span lines.
+var helmAction = regexp.MustCompile(`(?s){{.*?}}`)
+
+// actionMark stands in for an action while deTemplate works line by line.
+const actionMark = "\x00"
+
+// templatedValue stands in for Helm actions in a de-templated file.
+const templatedValue = "TEMPLATED"
+
+// loadChartState reads the state of the chart in dir. It returns nil if dir
+// holds no chart.
+func loadChartState(dir string) (*chartState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		Version     string            `yaml:"version"`
+		AppVersion  string            `yaml:"appVersion"`
+		Annotations map[string]string `yaml:"annotations"`
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("Chart.yaml: %w", err)
+	}
+	s := &chartState{
+		Version:    meta.Version,
+		AppVersion: meta.AppVersion,
+		Images:     make(map[string]string),
+		Env:        make(map[string]string),
+		Probes:     make(map[string]string),
+		Rules:      make(map[string]bool),
+		CRDs:       make(map[string]crdState),
+	}
+	if c := meta.Annotations["artifacthub.io/changes"]; c != "" {
+		if err := yaml.Unmarshal([]byte(c), &s.Changes); err != nil {
+			return nil, fmt.Errorf("Chart.yaml: artifacthub.io/changes: %w", err)
+		}
+	}
+
+	values, err := LoadValues(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	images, err := values.ResolveImages(meta.AppVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		s.Images[img.Name] = img.Image.String()
+	}
+
+	templates, err := filepath.Glob(filepath.Join(dir, "templates", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := filepath.Glob(filepath.Join(dir, "templates", "upstream", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range append(templates, upstream...) {
+		if err := s.addTemplate(t, values); err != nil {
+			rel, _ := filepath.Rel(dir, t)
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	s.dropNarrowedRoles()
+
+	crds, err := filepath.Glob(filepath.Join(dir, "crds", "*"))
+	if err != nil {
+		return nil, err
+	}
+	split, err := filepath.Glob(filepath.Join(dir, "charts", CRDChartName, "templates", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	crds = append(crds, split...)
+	for _, c := range crds {
+		if err := s.addCRD(c); err != nil {
+			rel, _ := filepath.Rel(dir, c)
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	return s, nil
+}
+
+// deTemplate turns a chart template into plain YAML: lines holding only Helm
+// actions are dropped and other actions replaced by templatedValue. An action
+// spanning lines counts as one, on the line it starts on. Trim markers are
+// not applied: the generator's templates keep their structure outside of
+// actions, so what remains parses.
+func deTemplate(data []byte) []byte {
+	marked := helmAction.ReplaceAllString(string(data), actionMark)
+	var lines []string
+	for _, line := range strings.Split(marked, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && strings.Trim(trimmed, actionMark+" \t") == "" {
+			continue
+		}
+		lines = append(lines, strings.ReplaceAll(line, actionMark, templatedValue))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func (s *chartState) addTemplate(path string, values Values) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(deTemplate(data)))
+	for {
+		var doc map[string]interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		kind, _ := doc["kind"].(string)
+		metadata, _ := doc["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		// Names are prefixed with the release's fullname; describe them
+		// with the default one.
+		name = strings.ReplaceAll(name, templatedValue, Name)
+		switch kind {
+		case "Deployment":
+			// The operator's probe settings are values; the rest is inline.
+			var probeValues map[string]interface{}
+			if filepath.Base(path) == "deployment.yaml" {
+				probeValues, _ = values["probes"].(map[string]interface{})
+			}
+			s.addDeployment(doc, probeValues)
+		case "ClusterRole", "Role":
+			s.addRules(kind, name, doc["rules"])
+		}
+	}
+}
+
+func (s *chartState) addDeployment(doc map[string]interface{}, probeValues map[string]interface{}) {
+	spec := nestedMap(doc, "spec", "template", "spec")
+	for _, list := range []string{"initContainers", "containers"} {
+		containers, _ := spec[list].([]interface{})
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			cname, _ := container["name"].(string)
+			env, _ := container["env"].([]interface{})
+			for _, e := range env {
+				ev, _ := e.(map[string]interface{})
+				name, _ := ev["name"].(string)
+				value := scalarString(ev["value"])
+				if from, ok := ev["valueFrom"]; ok {
+					value = canonicalJSON(from)
+				}
+				s.Env[cname+" "+name] = value
+			}
+			for _, probe := range []string{"livenessProbe", "readinessProbe", "startupProbe"} {
+				p, ok := container[probe].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				settings, _ := probeValues[strings.TrimSuffix(probe, "Probe")].(map[string]interface{})
+				merged := make(map[string]interface{}, len(p)+len(settings))
+				for k, v := range p {
+					merged[k] = v
+				}
+				for k, v := range settings {
+					if k != "enabled" {
+						merged[k] = v
+					}
+				}
+				s.Probes[cname+" "+probe] = canonicalJSON(merged)
+			}
+		}
+	}
+}
+
+func (s *chartState) addRules(kind, name string, rules interface{}) {
+	list, _ := rules.([]interface{})
+	for _, r := range list {
+		rule, _ := r.(map[string]interface{})
+		groups := stringList(rule["apiGroups"])
+		if len(groups) == 0 {
+			groups = []string{""}
+		}
+		resources := stringList(rule["resources"])
+		if urls := stringList(rule["nonResourceURLs"]); len(urls) > 0 {
+			resources = append(resources, urls...)
+		}
+		for _, g := range groups {
+			for _, res := range resources {
+				for _, verb := range stringList(rule["verbs"]) {
+					s.Rules[fmt.Sprintf("%s %s: %s %s", kind, name, qualifiedResource(g, res), verb)] = true
+				}
+			}
+		}
+	}
+}
+
+// dropNarrowedRoles removes the rules of Roles named like a ClusterRole:
+// those the chart narrows from the ClusterRole for clusterScoped.enabled=false
+// grant nothing the ClusterRole does not.
+func (s *chartState) dropNarrowedRoles() {
+	clusterRoles := make(map[string]bool)
+	for rule := range s.Rules {
+		if target, ok := strings.CutPrefix(rule, "ClusterRole "); ok {
+			name, _, _ := strings.Cut(target, ": ")
+			clusterRoles[name] = true
+		}
+	}
+	for rule := range s.Rules {
+		if target, ok := strings.CutPrefix(rule, "Role "); ok {
+			name, _, _ := strings.Cut(target, ": ")
+			if clusterRoles[name] {
+				delete(s.Rules, rule)
+			}
+		}
+	}
+}
+
+func qualifiedResource(group, resource string) string {
+	if group == "" {
+		return resource
+	}
+	return group + "/" + resource
+}
+
+// addCRD reads a CRD from crds/ or, de-templated, from the CRD chart.
+func (s *chartState) addCRD(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data = deTemplate(data)
+	var doc struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+		Spec struct {
+			Names struct {
+				Kind string `yaml:"kind"`
+			} `yaml:"names"`
+			Versions []struct {
+				Name       string `yaml:"name"`
+				Deprecated bool   `yaml:"deprecated"`
+				Schema     struct {
+					OpenAPIV3Schema map[string]interface{} `yaml:"openAPIV3Schema"`
+				} `yaml:"schema"`
+			} `yaml:"versions"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	crd := crdState{Kind: doc.Spec.Names.Kind, Versions: make(map[string]crdVersionState)}
+	for _, v := range doc.Spec.Versions {
+		fields := make(map[string]bool)
+		schemaFields(v.Schema.OpenAPIV3Schema, "", fields)
+		crd.Versions[v.Name] = crdVersionState{
+			Deprecated: v.Deprecated,
+			Schema:     canonicalJSON(v.Schema.OpenAPIV3Schema),
+			Fields:     fields,
+		}
+	}
+	s.CRDs[doc.Metadata.Name] = crd
+	return nil
+}
+
+// schemaFields collects the dotted paths of every property in an OpenAPI
+// schema; array items add "[]".
+func schemaFields(schema map[string]interface{}, prefix string, out map[string]bool) {
+	props, _ := schema["properties"].(map[string]interface{})
+	for name, p := range props {
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		out[path] = true
+		sub, _ := p.(map[string]interface{})
+		schemaFields(sub, path, out)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		schemaFields(items, prefix+"[]", out)
+	}
+}
+
+func nestedMap(m map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, k := range keys {
+		m, _ = m[k].(map[string]interface{})
+	}
+	return m
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		out = append(out, scalarString(item))
+	}
+	return out
+}
+
+// canonicalJSON encodes v with sorted keys, so equal values compare equal.
+func canonicalJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// diffStates describes how the chart changed from old to cur, ordered by
+// kind.
+func diffStates(old, cur *chartState) []Change {
+	var changes []Change
+	add := func(kind, format string, args ...interface{}) {
+		changes = append(changes, Change{Kind: kind, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if old.AppVersion != cur.AppVersion {
+		add("changed", "Update the Keycloak operator from %s to %s", old.AppVersion, cur.AppVersion)
+	}
+
+	for _, name := range sortedUnion(old.Images, cur.Images) {
+		o, inOld := old.Images[name]
+		c, inCur := cur.Images[name]
+		switch {
+		case !inOld:
+			add("added", "Add image %s: %s", name, c)
+		case !inCur:
+			add("removed", "Remove image %s", name)
+		case o != c:
+			add("changed", "Update image %s to %s", name, c)
+		}
+	}
+
+	for _, key := range sortedUnion(old.Env, cur.Env) {
+		container, name, _ := strings.Cut(key, " ")
+		o, inOld := old.Env[key]
+		c, inCur := cur.Env[key]
+		switch {
+		case !inOld:
+			add("added", "Set env %s on container %s", name, container)
+		case !inCur:
+			add("removed", "Remove env %s from container %s", name, container)
+		case o != c && o != templatedValue:
+			add("changed", "Change env %s of container %s", name, container)
+		}
+	}
+
+	for _, key := range sortedUnion(old.Probes, cur.Probes) {
+		container, probe, _ := strings.Cut(key, " ")
+		_, inOld := old.Probes[key]
+		_, inCur := cur.Probes[key]
+		switch {
+		case !inOld:
+			add("added", "Add %s to container %s", probe, container)
+		case !inCur:
+			add("removed", "Remove %s from container %s", probe, container)
+		case old.Probes[key] != cur.Probes[key]:
+			add("changed", "Change %s of container %s", probe, container)
+		}
+	}
+
+	granted, revoked := ruleChanges(old.Rules, cur.Rules)
+	for _, g := range granted {
+		add("security", "Grant %s", g)
+	}
+	for _, r := range revoked {
+		add("security", "Revoke %s", r)
+	}
+
+	for _, name := range sortedUnion(old.CRDs, cur.CRDs) {
+		o, inOld := old.CRDs[name]
+		c, inCur := cur.CRDs[name]
+		switch {
+		case !inOld:
+			add("added", "Add CRD %s", name)
+			continue
+		case !inCur:
+			add("removed", "Remove CRD %s", name)
+			continue
+		}
+		for _, v := range sortedUnion(o.Versions, c.Versions) {
+			ov, inOld := o.Versions[v]
+			cv, inCur := c.Versions[v]
+			switch {
+			case !inOld:
+				add("added", "Add %s %s", c.Kind, v)
+				continue
+			case !inCur:
+				add("removed", "Remove %s %s", o.Kind, v)
+				continue
+			}
+			if cv.Deprecated && !ov.Deprecated {
+				add("deprecated", "Deprecate %s %s", c.Kind, v)
+			}
+			if ov.Schema == cv.Schema {
+				continue
+			}
+			added, removed := fieldChanges(ov.Fields, cv.Fields)
+			for _, f := range added {
+				add("added", "Add %s %s field %s", c.Kind, v, f)
+			}
+			for _, f := range removed {
+				add("removed", "Remove %s %s field %s", c.Kind, v, f)
+			}
+			if len(added) == 0 && len(removed) == 0 {
+				add("changed", "Update the %s %s schema", c.Kind, v)
+			}
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return kindRank(changes[i].Kind) < kindRank(changes[j].Kind)
+	})
+	return changes
+}
+
+func kindRank(kind string) int {
+	for i, k := range changeKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(changeKinds)
+}
+
+// ruleChanges describes the RBAC permissions only in cur and only in old,
+// grouping verbs by role and resource.
+func ruleChanges(old, cur map[string]bool) (granted, revoked []string) {
+	group := func(from, other map[string]bool) []string {
+		verbs := make(map[string][]string)
+		for rule := range from {
+			if other[rule] {
+				continue
+			}
+			i := strings.LastIndex(rule, " ")
+			verbs[rule[:i]] = append(verbs[rule[:i]], rule[i+1:])
+		}
+		var out []string
+		for target, vs := range verbs {
+			sort.Strings(vs)
+			role, resource, _ := strings.Cut(target, ": ")
+			out = append(out, fmt.Sprintf("%s on %s to %s", strings.Join(vs, ", "), resource, role))
+		}
+		sort.Strings(out)
+		return out
+	}
+	return group(cur, old), group(old, cur)
+}
+
+// fieldChanges returns the topmost fields only in cur and only in old; the
+// children of a new field are not listed separately.
+func fieldChanges(old, cur map[string]bool) (added, removed []string) {
+	top := func(from, other map[string]bool) []string {
+		var out []string
+		for f := range from {
+			if other[f] {
+				continue
+			}
+			if i := strings.LastIndex(f, "."); i > 0 && from[f[:i]] && !other[f[:i]] {
+				continue
+			}
+			out = append(out, f)
+		}
+		sort.Strings(out)
+		return out
+	}
+	return top(cur, old), top(old, cur)
+}
+
+// sortedUnion returns the keys of two maps of the same type, sorted.
+func sortedUnion[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// prependChangelog adds an entry for a release at the top of the changelog
+// at path, below its title, creating the file if needed.
+func prependChangelog(path, chartVersion, appVersion string, changes []Change, date time.Time) error {
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "## %s - %s\n\nKeycloak operator %s.\n", chartVersion, date.UTC().Format("2006-01-02"), appVersion)
+	if len(changes) == 0 {
+		entry.WriteString("\nNo changes to the upstream content.\n")
+	}
+	for _, kind := range changeKinds {
+		heading := false
+		for _, c := range changes {
+			if c.Kind != kind {
+				continue
+			}
+			if !heading {
+				fmt.Fprintf(&entry, "\n### %s\n\n", strings.ToUpper(kind[:1])+kind[1:])
+				heading = true
+			}
+			fmt.Fprintf(&entry, "- %s\n", c.Description)
+		}
+	}
+
+	const title = "# Changelog\n"
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	body := strings.TrimPrefix(string(existing), title)
+	body = strings.TrimLeft(body, "\n")
+	out := title + "\n" + entry.String()
+	if body != "" {
+		out += "\n" + body
+	}
+	return os.WriteFile(path, []byte(out), 0o644)
+}
@@ -0,0 +1,105 @@
+package chart
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChange(t *testing.T) {
+	got, err := ParseChange("added: Add the service.extraPorts value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Change{Kind: "added", Description: "Add the service.extraPorts value"}); got != want {
+		t.Errorf("ParseChange = %+v, want %+v", got, want)
+	}
+	for _, s := range []string{"Add a value", "added:", "fixed:Fix a bug"} {
+		if _, err := ParseChange(s); err == nil {
+			t.Errorf("ParseChange(%q) succeeded", s)
+		}
+	}
+}
+
+func TestChartChangesInChangelog(t *testing.T) {
+	detected := []Change{
+		{"security", "Grant get on secrets to ClusterRole keycloak-operator"},
+		{"changed", "Update the Keycloak operator from 26.5.2 to 26.5.3"},
+	}
+	given := []Change{
+		{"added", "Add the service.extraPorts value"},
+		{"changed", "Update the Keycloak operator from 26.5.2 to 26.5.3"},
+	}
+	changes := addChanges(detected, given)
+	want := []Change{
+		{"added", "Add the service.extraPorts value"},
+		{"changed", "Update the Keycloak operator from 26.5.2 to 26.5.3"},
+		{"security", "Grant get on secrets to ClusterRole keycloak-operator"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("addChanges = %+v, want %+v", changes, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "CHANGELOG.md")
+	if err := os.WriteFile(path, []byte("# Changelog\n\n## 0.5.0 - 2026-01-01\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := prependChangelog(path, "0.6.0", "26.5.3", changes, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, s := range []string{
+		"# Changelog\n\n## 0.6.0 - 2026-10-18\n",
+		"### Added\n\n- Add the service.extraPorts value\n",
+		"### Security\n\n- Grant get on secrets",
+		"\n## 0.5.0 - 2026-01-01\n",
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("changelog lacks %q:\n%s", s, data)
+		}
+	}
+}
+
+func TestDeTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			"inline action",
+			"metadata:\n  name: {{ include \"keycloak-operator.fullname\" . }}-x\n",
+			"metadata:\n  name: TEMPLATED-x\n",
+		},
+		{
+			"lines of actions only",
+			"{{- if .Values.enabled -}}\nkind: Role\n  {{- with .Values.labels }} {{ toYaml . }}\n{{- end }}\n",
+			"kind: Role\n",
+		},
+		{
+			"multi-line comment",
+			"{{/*\nSelector labels\n*/}}\nkind: Service\n",
+			"kind: Service\n",
+		},
+		{
+			"multi-line action with trim markers",
+			"spec:\n  {{- include \"x\" (dict\n      \"a\" 1\n      \"b\" 2) | nindent 2 -}}\n  replicas: 1\n",
+			"spec:\n  replicas: 1\n",
+		},
+		{
+			"multi-line action after a key",
+			"data:\n  value: {{ printf \"%s\"\n    .Values.x }}\n  other: b\n",
+			"data:\n  value: TEMPLATED\n  other: b\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(deTemplate([]byte(tt.in))); got != tt.want {
+				t.Errorf("deTemplate:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
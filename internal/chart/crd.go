@@ -0,0 +1,212 @@
+package chart
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CRD describes a CustomResourceDefinition the chart ships in crds/.
+type CRD struct {
+	Name     string   // metadata.name, e.g. keycloaks.k8s.keycloak.org
+	Group    string   // spec.group
+	Kind     string   // spec.names.kind
+	Singular string   // spec.names.singular
+	Plural   string   // spec.names.plural
+	Scope    string   // spec.scope, Namespaced or Cluster
+	Versions []string // spec.versions[].name
+	Storage  string   // the version marked storage, or the first one
+	Digest   string   // sha256:<hex> of the file
+
+	// Description is that of the storage version's schema, or of its spec
+	// property.
+	Description string
+
+	// Schema is the openAPIV3Schema of the storage version.
+	Schema map[string]interface{}
+
+	// APIVersion, AnnotationsYAML and BodyYAML render the CRD as a
+	// template of the CRD chart, as for a Resource.
+	APIVersion      string
+	AnnotationsYAML string
+	BodyYAML        string
+}
+
+// DisplayName is the kind split into words, e.g. Keycloak Realm Import.
+func (c CRD) DisplayName() string {
+	var b strings.Builder
+	for i, r := range c.Kind {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Summary is the Description, or a sentence naming the kind when the CRD
+// has none.
+func (c CRD) Summary() string {
+	if c.Description != "" {
+		return c.Description
+	}
+	return c.DisplayName() + " managed by the Keycloak operator"
+}
+
+// Example is a minimal custom resource of the storage version, synthesized
+// from its schema by exampleValue, plus the exampleHints its schema takes.
+func (c CRD) Example() map[string]interface{} {
+	example := map[string]interface{}{
+		"apiVersion": c.Group + "/" + c.Storage,
+		"kind":       c.Kind,
+		"metadata":   map[string]interface{}{"name": exampleName(c.Singular)},
+	}
+	if spec, ok := schemaProperty(c.Schema, "spec"); ok {
+		example["spec"] = exampleValue("spec", spec)
+	}
+	for _, h := range c.hints() {
+		setPath(example, h.Path, h.Value)
+	}
+	return example
+}
+
+// parseCRDs describes the CRD files in sources.
+func parseCRDs(sources []Source) ([]CRD, error) {
+	crds := make([]CRD, 0, len(sources))
+	for _, s := range sources {
+		var doc struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+			Spec struct {
+				Group string `yaml:"group"`
+				Scope string `yaml:"scope"`
+				Names struct {
+					Kind     string `yaml:"kind"`
+					Singular string `yaml:"singular"`
+					Plural   string `yaml:"plural"`
+				} `yaml:"names"`
+				Versions []struct {
+					Name    string `yaml:"name"`
+					Storage bool   `yaml:"storage"`
+					Schema  struct {
+						OpenAPIV3Schema map[string]interface{} `yaml:"openAPIV3Schema"`
+					} `yaml:"schema"`
+				} `yaml:"versions"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal(s.Data, &doc); err != nil {
+			return nil, fmt.Errorf("CRD %s: %w", s.Name, err)
+		}
+		if doc.Kind != "CustomResourceDefinition" || doc.Metadata.Name == "" {
+			return nil, fmt.Errorf("CRD %s: not a CustomResourceDefinition", s.Name)
+		}
+		crd := CRD{
+			Name:     doc.Metadata.Name,
+			Group:    doc.Spec.Group,
+			Kind:     doc.Spec.Names.Kind,
+			Singular: doc.Spec.Names.Singular,
+			Plural:   doc.Spec.Names.Plural,
+			Scope:    doc.Spec.Scope,
+			Digest:   fmt.Sprintf("sha256:%x", sha256.Sum256(s.Data)),
+		}
+		if crd.Singular == "" {
+			crd.Singular = strings.ToLower(crd.Kind)
+		}
+		if err := crd.setTemplate(s.Data); err != nil {
+			return nil, fmt.Errorf("CRD %s: %w", s.Name, err)
+		}
+		for _, v := range doc.Spec.Versions {
+			crd.Versions = append(crd.Versions, v.Name)
+			if v.Storage || crd.Storage == "" {
+				schema := v.Schema.OpenAPIV3Schema
+				crd.Storage, crd.Schema = v.Name, schema
+				crd.Description, _ = schema["description"].(string)
+				if spec, ok := schemaProperty(schema, "spec"); ok && crd.Description == "" {
+					crd.Description, _ = spec["description"].(string)
+				}
+			}
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// setTemplate fills the fields that render the CRD in the CRD chart from
+// the file's content. Like an upstream resource, the labels are replaced by
+// the chart labels.
+func (c *CRD) setTemplate(data []byte) error {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	c.APIVersion, _ = raw["apiVersion"].(string)
+
+	metadata, _ := raw["metadata"].(map[string]interface{})
+	if annotations, ok := metadata["annotations"]; ok {
+		y, err := marshalYAML(annotations)
+		if err != nil {
+			return fmt.Errorf("marshaling annotations: %w", err)
+		}
+		c.AnnotationsYAML = escapeHelm(y)
+	}
+
+	body := make(map[string]interface{})
+	for k, v := range raw {
+		if k != "apiVersion" && k != "kind" && k != "metadata" {
+			body[k] = v
+		}
+	}
+	y, err := marshalYAML(body)
+	if err != nil {
+		return fmt.Errorf("marshaling CRD: %w", err)
+	}
+	c.BodyYAML = escapeHelm(y)
+	return nil
+}
+
+// exampleYAML renders the Example of a CRD for a template Helm renders.
+func exampleYAML(c CRD) (string, error) {
+	out, err := marshalYAML(c.Example())
+	if err != nil {
+		return "", err
+	}
+	return escapeHelm(out), nil
+}
+
+// writeExamples writes the Example of every CRD to dir as <singular>.yaml.
+func writeExamples(dir string, crds []CRD) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, c := range crds {
+		out, err := marshalYAML(c.Example())
+		if err != nil {
+			return err
+		}
+		data := fmt.Sprintf("# A minimal %s, synthesized from the schema of %s %s.\n", c.Kind, c.Name, c.Storage)
+		if paths := hintPaths(c); len(paths) > 0 {
+			data += fmt.Sprintf("# Set %s to your own values.\n", strings.Join(paths, ", "))
+		}
+		data += out + "\n"
+		if err := os.WriteFile(filepath.Join(dir, c.Singular+".yaml"), []byte(data), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// crdsExamples renders the Example of every CRD as a YAML list.
+func crdsExamples(crds []CRD) (string, error) {
+	examples := make([]map[string]interface{}, 0, len(crds))
+	for _, c := range crds {
+		examples = append(examples, c.Example())
+	}
+	return marshalYAML(examples)
+}
@@ -0,0 +1,177 @@
+package chart
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Drop is an upstream resource or field that did not make it into the chart.
+type Drop struct {
+	Resource string // Kind/name
+	Path     string // JSON path within the resource; "$" is the whole resource
+}
+
+func (d Drop) String() string {
+	return d.Resource + " " + d.Path
+}
+
+// fieldTracker records the JSON paths of an upstream resource that the parser
+// consumed. Everything else is reported as a Drop.
+type fieldTracker map[string]bool
+
+func (t fieldTracker) use(paths ...string) {
+	for _, p := range paths {
+		t[p] = true
+	}
+}
+
+// useUnmodelled marks every key of m that is not in modelled, since those are
+// rendered verbatim by passthroughYAML.
+func (t fieldTracker) useUnmodelled(path string, m map[string]interface{}, modelled map[string]bool) {
+	for k := range m {
+		if !modelled[k] {
+			t.use(childPath(path, k))
+		}
+	}
+}
+
+// usedBelow reports whether any path under path was consumed.
+func (t fieldTracker) usedBelow(path string) bool {
+	for p := range t {
+		if strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// drops walks the resource and returns the paths that were not consumed. A
+// subtree with nothing consumed is reported once, at its root.
+func (r rawResource) drops() []Drop {
+	var paths []string
+	walkDrops(r.Raw, "$", r.Used, &paths)
+
+	resource := r.Kind + "/" + r.Name
+	out := make([]Drop, len(paths))
+	for i, p := range paths {
+		out[i] = Drop{Resource: resource, Path: p}
+	}
+	return out
+}
+
+func walkDrops(v interface{}, path string, used fieldTracker, out *[]string) {
+	if used[path] {
+		return
+	}
+	if !used.usedBelow(path) {
+		*out = append(*out, path)
+		return
+	}
+
+	switch n := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkDrops(n[k], childPath(path, k), used, out)
+		}
+	case []interface{}:
+		for i, e := range n {
+			walkDrops(e, indexPath(path, i), used, out)
+		}
+	}
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// childPath appends a map key to a JSON path, using bracket notation for keys
+// such as annotation names that are not plain identifiers.
+func childPath(path, key string) string {
+	if identifierRe.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "['" + key + "']"
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// IgnoreList holds acknowledged drops loaded from an ignore file.
+type IgnoreList []ignoreRule
+
+type ignoreRule struct {
+	resource *regexp.Regexp
+	path     *regexp.Regexp
+}
+
+// LoadIgnore reads an ignore file. Each non-empty line that does not start
+// with # holds a resource (Kind/name) and a JSON path, separated by
+// whitespace. Both may use * as a wildcard, and a path also covers everything
+// below it.
+func LoadIgnore(path string) (IgnoreList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list IgnoreList
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected <Kind/name> <path>, got %q", path, n, line)
+		}
+		list = append(list, ignoreRule{
+			resource: globRegexp(fields[0], "$"),
+			path:     globRegexp(fields[1], `($|\.|\[)`),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Unacknowledged returns the drops that no rule in the list matches.
+func (l IgnoreList) Unacknowledged(drops []Drop) []Drop {
+	var out []Drop
+	for _, d := range drops {
+		if !l.matches(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Excludes reports whether the list acknowledges dropping the resource
+// (Kind/name) as a whole.
+func (l IgnoreList) Excludes(resource string) bool {
+	return l.matches(Drop{Resource: resource, Path: "$"})
+}
+
+func (l IgnoreList) matches(d Drop) bool {
+	for _, r := range l {
+		if r.resource.MatchString(d.Resource) && r.path.MatchString(d.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func globRegexp(glob, suffix string) *regexp.Regexp {
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*")
+	return regexp.MustCompile("^" + quoted + suffix)
+}
@@ -0,0 +1,58 @@
+package chart
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIgnoreListUnacknowledged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upstream.ignore")
+	ignore := `# acknowledged drops
+Deployment/keycloak-operator $.spec.template.metadata.annotations
+
+ClusterRole/* $.aggregationRule
+Service/* $.spec.ports[*].appProtocol
+`
+	if err := os.WriteFile(path, []byte(ignore), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadIgnore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drops := []Drop{
+		{"Deployment/keycloak-operator", "$.spec.template.metadata.annotations"},
+		{"Deployment/keycloak-operator", "$.spec.template.metadata.annotations.checksum"},
+		{"Deployment/keycloak-operator", "$.spec.template.metadata.annotationsExtra"},
+		{"Deployment/other", "$.spec.template.metadata.annotations"},
+		{"ClusterRole/keycloak-operator-clusterrole", "$.aggregationRule.clusterRoleSelectors[0]"},
+		{"Role/keycloak-operator-role", "$.aggregationRule"},
+		{"Service/keycloak-operator", "$.spec.ports[1].appProtocol"},
+		{"Service/keycloak-operator", "$.spec.ports[1].nodePort"},
+	}
+	want := []Drop{
+		{"Deployment/keycloak-operator", "$.spec.template.metadata.annotationsExtra"},
+		{"Deployment/other", "$.spec.template.metadata.annotations"},
+		{"Role/keycloak-operator-role", "$.aggregationRule"},
+		{"Service/keycloak-operator", "$.spec.ports[1].nodePort"},
+	}
+	if got := list.Unacknowledged(drops); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unacknowledged =\n%v\nwant\n%v", got, want)
+	}
+	if got := IgnoreList(nil).Unacknowledged(drops); !reflect.DeepEqual(got, drops) {
+		t.Errorf("an empty list acknowledged %v", got)
+	}
+}
+
+func TestLoadIgnoreInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upstream.ignore")
+	if err := os.WriteFile(path, []byte("Deployment/keycloak-operator\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIgnore(path); err == nil {
+		t.Error("LoadIgnore accepted a line without a path")
+	}
+}
@@ -0,0 +1,436 @@
+package chart
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// exampleName is the metadata.name of the example of a kind, e.g.
+// my-keycloak.
+func exampleName(singular string) string {
+	return "my-" + singular
+}
+
+func schemaProperty(schema map[string]interface{}, name string) (map[string]interface{}, bool) {
+	props, _ := schema["properties"].(map[string]interface{})
+	p, ok := props[name].(map[string]interface{})
+	return p, ok
+}
+
+// exampleHints are fields a resource commonly needs to serve traffic, such
+// as the hostname and TLS Secret of a Keycloak, that its schema does not
+// require. An example sets one when its schema declares the field and the
+// value validates. {name} is replaced by the example's name.
+var exampleHints = []exampleHint{
+	{"spec.hostname.hostname", "{name}.example.com"},
+	{"spec.http.tlsSecret", "{name}-tls"},
+}
+
+type exampleHint struct {
+	Path  string
+	Value interface{}
+}
+
+// hints returns the exampleHints that apply to the schema of the CRD.
+func (c CRD) hints() []exampleHint {
+	var out []exampleHint
+	for _, h := range exampleHints {
+		schema, ok := c.Schema, true
+		for _, p := range strings.Split(h.Path, ".") {
+			if schema, ok = schemaProperty(schema, p); !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		v := h.Value
+		if s, isString := v.(string); isString {
+			v = strings.ReplaceAll(s, "{name}", exampleName(c.Singular))
+		}
+		if validateExample(v, schema, h.Path) == nil {
+			out = append(out, exampleHint{h.Path, v})
+		}
+	}
+	return out
+}
+
+// hintPaths lists the fields of the CRD's Example set by exampleHints.
+func hintPaths(c CRD) []string {
+	var paths []string
+	for _, h := range c.hints() {
+		paths = append(paths, h.Path)
+	}
+	return paths
+}
+
+// setPath sets the field at the dotted path in m, adding the objects on the
+// way.
+func setPath(m map[string]interface{}, path string, v interface{}) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = v
+}
+
+// exampleValue synthesizes the smallest value the schema accepts for the
+// field name: its default or first enum value if it has one; for an object,
+// its required properties plus those with a default; for an array, minItems
+// items; for a string, one that fits its format, pattern and length bounds;
+// for a number, the lowest one within its bounds. A string field named
+// <kind>CRName references the example of that kind, so examples fit
+// together.
+func exampleValue(name string, schema map[string]interface{}) interface{} {
+	if d, ok := schema["default"]; ok {
+		return d
+	}
+	if enum, _ := schema["enum"].([]interface{}); len(enum) > 0 {
+		return enum[0]
+	}
+	if schema["x-kubernetes-int-or-string"] == true {
+		return 1
+	}
+
+	switch schema["type"] {
+	case "object":
+		out := make(map[string]interface{})
+		props, _ := schema["properties"].(map[string]interface{})
+		for _, r := range stringList(schema["required"]) {
+			if p, ok := props[r].(map[string]interface{}); ok {
+				out[r] = exampleValue(r, p)
+			} else {
+				out[r] = map[string]interface{}{}
+			}
+		}
+		for p, s := range props {
+			sub, _ := s.(map[string]interface{})
+			if d, ok := sub["default"]; ok {
+				if _, set := out[p]; !set {
+					out[p] = d
+				}
+			}
+		}
+		return out
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		out := []interface{}{}
+		for i := 0; i < schemaInt(schema["minItems"], 0); i++ {
+			out = append(out, exampleValue(name, items))
+		}
+		return out
+	case "string":
+		if kind, ok := strings.CutSuffix(name, "CRName"); ok && kind != "" {
+			return exampleName(strings.ToLower(kind))
+		}
+		return exampleString(schema)
+	case "integer", "number":
+		n := 1.0
+		if min, ok := schema["minimum"]; ok {
+			n = math.Ceil(schemaFloat(min))
+			if schema["exclusiveMinimum"] == true && n == schemaFloat(min) {
+				n++
+			}
+		} else if max, ok := schema["maximum"]; ok && n > schemaFloat(max) {
+			n = math.Floor(schemaFloat(max))
+			if schema["exclusiveMaximum"] == true && n == schemaFloat(max) {
+				n--
+			}
+		}
+		return int(n)
+	case "boolean":
+		return false
+	}
+	return map[string]interface{}{}
+}
+
+// formatExamples are values of the string formats Kubernetes validates.
+var formatExamples = map[string]string{
+	"date":      "1970-01-01",
+	"date-time": "1970-01-01T00:00:00Z",
+	"duration":  "1s",
+	"byte":      "ZXhhbXBsZQ==",
+	"email":     "user@example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"cidr":      "192.0.2.0/24",
+	"mac":       "00:00:5e:00:53:01",
+	"uri":       "https://example.com",
+	"uuid":      "00000000-0000-4000-8000-000000000000",
+	"uuid4":     "00000000-0000-4000-8000-000000000000",
+}
+
+// exampleString returns "example", or the example of the schema's format,
+// if it fits the schema's pattern and length bounds. Otherwise it builds a
+// string from the pattern, or pads or cuts "example" to length.
+func exampleString(schema map[string]interface{}) string {
+	s := "example"
+	if f, ok := formatExamples[fmt.Sprint(schema["format"])]; ok {
+		s = f
+	}
+	min, max := schemaInt(schema["minLength"], 0), schemaInt(schema["maxLength"], -1)
+	pattern, _ := schema["pattern"].(string)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		// validateExample reports the pattern.
+		return s
+	}
+	fits := func(s string) bool {
+		return len(s) >= min && (max < 0 || len(s) <= max) && re.MatchString(s)
+	}
+	if fits(s) {
+		return s
+	}
+	if pattern == "" {
+		if len(s) < min {
+			s += strings.Repeat("x", min-len(s))
+		}
+		if max >= 0 && len(s) > max {
+			s = s[:max]
+		}
+		return s
+	}
+	// Repeat unbounded parts of the pattern more until the string is long
+	// enough.
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return s
+	}
+	for extra := 0; extra <= min; extra++ {
+		var b strings.Builder
+		writePatternExample(&b, parsed, extra)
+		if fits(b.String()) {
+			return b.String()
+		}
+	}
+	return s
+}
+
+// writePatternExample writes a short string that re matches: the first
+// alternative of each choice, a letter or digit of each character class and
+// the minimum of each repetition, plus extra for unbounded ones.
+func writePatternExample(b *strings.Builder, re *syntax.Regexp, extra int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		b.WriteRune(classExample(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte('x')
+	case syntax.OpCapture:
+		writePatternExample(b, re.Sub[0], extra)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writePatternExample(b, sub, extra)
+		}
+	case syntax.OpAlternate:
+		writePatternExample(b, re.Sub[0], extra)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		n, max := 0, -1
+		switch re.Op {
+		case syntax.OpPlus:
+			n = 1
+		case syntax.OpQuest:
+			max = 1
+		case syntax.OpRepeat:
+			n, max = re.Min, re.Max
+		}
+		if n += extra; max >= 0 && n > max {
+			n = max
+		}
+		for i := 0; i < n; i++ {
+			writePatternExample(b, re.Sub[0], extra)
+		}
+	}
+}
+
+// classExample picks a rune from a character class, given as ranges,
+// preferring a lower-case letter or digit.
+func classExample(ranges []rune) rune {
+	for _, r := range "ax0A-._" {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return r
+			}
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i+1] > ' ' {
+			return max(ranges[i], '!')
+		}
+	}
+	return 'x'
+}
+
+// validate checks the Example of a CRD against its schema. apiVersion, kind
+// and metadata are left to the API server, as for any resource.
+func (c CRD) validate() error {
+	example := c.Example()
+	for _, k := range []string{"apiVersion", "kind", "metadata"} {
+		delete(example, k)
+	}
+	return validateExample(example, c.Schema, c.Kind)
+}
+
+// validateExample checks v against a structural OpenAPI v3 schema: types,
+// required properties, enums, bounds, patterns and, unless the schema
+// preserves unknown fields, that every property is declared.
+func validateExample(v interface{}, schema map[string]interface{}, path string) error {
+	if v == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+	if enum, _ := schema["enum"].([]interface{}); len(enum) > 0 {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+		}
+	}
+	if schema["x-kubernetes-int-or-string"] == true {
+		switch v.(type) {
+		case int, string:
+			return nil
+		}
+		return fmt.Errorf("%s: must be an integer or a string", path)
+	}
+
+	switch schema["type"] {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, r := range stringList(schema["required"]) {
+			if _, ok := m[r]; !ok {
+				return fmt.Errorf("%s: missing required field %s", path, r)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := props[k].(map[string]interface{})
+			switch {
+			case ok:
+			case additional != nil:
+				sub = additional
+			case schema["x-kubernetes-preserve-unknown-fields"] == true:
+				continue
+			default:
+				return fmt.Errorf("%s: unknown field %s", path, k)
+			}
+			if err := validateExample(m[k], sub, path+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+		if min := schemaInt(schema["minItems"], 0); len(list) < min {
+			return fmt.Errorf("%s: needs at least %d items", path, min)
+		}
+		if max := schemaInt(schema["maxItems"], -1); max >= 0 && len(list) > max {
+			return fmt.Errorf("%s: allows at most %d items", path, max)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range list {
+			if err := validateExample(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		if min := schemaInt(schema["minLength"], 0); len(s) < min {
+			return fmt.Errorf("%s: must be at least %d characters", path, min)
+		}
+		if max := schemaInt(schema["maxLength"], -1); max >= 0 && len(s) > max {
+			return fmt.Errorf("%s: must be at most %d characters", path, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: pattern %q: %w", path, pattern, err)
+			}
+			if !re.MatchString(s) {
+				return fmt.Errorf("%s: %q does not match %q", path, s, pattern)
+			}
+		}
+	case "integer", "number":
+		var n float64
+		switch x := v.(type) {
+		case int:
+			n = float64(x)
+		case float64:
+			if schema["type"] == "integer" && x != math.Trunc(x) {
+				return fmt.Errorf("%s: must be an integer", path)
+			}
+			n = x
+		default:
+			return fmt.Errorf("%s: must be a number", path)
+		}
+		if min, ok := schema["minimum"]; ok {
+			if n < schemaFloat(min) {
+				return fmt.Errorf("%s: must be at least %v", path, min)
+			}
+			if schema["exclusiveMinimum"] == true && n == schemaFloat(min) {
+				return fmt.Errorf("%s: must be greater than %v", path, min)
+			}
+		}
+		if max, ok := schema["maximum"]; ok {
+			if n > schemaFloat(max) {
+				return fmt.Errorf("%s: must be at most %v", path, max)
+			}
+			if schema["exclusiveMaximum"] == true && n == schemaFloat(max) {
+				return fmt.Errorf("%s: must be less than %v", path, max)
+			}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+	}
+	return nil
+}
+
+func schemaInt(v interface{}, fallback int) int {
+	if v == nil {
+		return fallback
+	}
+	return int(schemaFloat(v))
+}
+
+func schemaFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package chart
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExampleValueValidates(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]interface{}
+		want   interface{}
+	}{
+		{"plain", map[string]interface{}{"type": "string"}, "example"},
+		{"minLength", map[string]interface{}{"type": "string", "minLength": 10}, "examplexxx"},
+		{"maxLength", map[string]interface{}{"type": "string", "maxLength": 3}, "exa"},
+		{"format", map[string]interface{}{"type": "string", "format": "date-time"}, "1970-01-01T00:00:00Z"},
+		{"pattern", map[string]interface{}{"type": "string", "pattern": `^[0-9]+(ms|s|m)$`}, "0ms"},
+		{"patternMinLength", map[string]interface{}{"type": "string", "pattern": `^[A-Z][a-z]*$`, "minLength": 3}, "Aaa"},
+		{"patternFormat", map[string]interface{}{"type": "string", "pattern": `^[a-z]+\.com$`, "format": "hostname"}, "example.com"},
+		{"patternClass", map[string]interface{}{"type": "string", "pattern": `^[^/]{2}$`}, "aa"},
+		{"minimum", map[string]interface{}{"type": "integer", "minimum": 3}, 3},
+		{"exclusiveMinimum", map[string]interface{}{"type": "integer", "minimum": 0, "exclusiveMinimum": true}, 1},
+		{"maximum", map[string]interface{}{"type": "integer", "maximum": 0, "exclusiveMaximum": true}, -1},
+		{"ref", map[string]interface{}{"type": "string"}, "my-keycloak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := tt.name
+			if field == "ref" {
+				field = "keycloakCRName"
+			}
+			got := exampleValue(field, tt.schema)
+			if got != tt.want {
+				t.Errorf("exampleValue = %v, want %v", got, tt.want)
+			}
+			if err := validateExample(got, tt.schema, field); err != nil {
+				t.Errorf("example does not validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateExampleExclusiveBounds(t *testing.T) {
+	schema := map[string]interface{}{"type": "integer", "minimum": 0, "exclusiveMinimum": true, "maximum": 5, "exclusiveMaximum": true}
+	for v, ok := range map[int]bool{0: false, 1: true, 4: true, 5: false} {
+		if err := validateExample(v, schema, "n"); (err == nil) != ok {
+			t.Errorf("validateExample(%d) = %v", v, err)
+		}
+	}
+}
+
+func TestExampleHints(t *testing.T) {
+	object := func(props map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	str := map[string]interface{}{"type": "string"}
+	tests := []struct {
+		name string
+		spec map[string]interface{}
+		want []exampleHint
+	}{
+		{
+			"declared",
+			object(map[string]interface{}{
+				"hostname": object(map[string]interface{}{"hostname": str}),
+				"http":     object(map[string]interface{}{"tlsSecret": str}),
+			}),
+			[]exampleHint{
+				{"spec.hostname.hostname", "my-widget.example.com"},
+				{"spec.http.tlsSecret", "my-widget-tls"},
+			},
+		},
+		{"undeclared", object(map[string]interface{}{"size": map[string]interface{}{"type": "integer"}}), nil},
+		{
+			"invalid",
+			object(map[string]interface{}{
+				"hostname": object(map[string]interface{}{"hostname": map[string]interface{}{"type": "string", "maxLength": 5}}),
+			}),
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CRD{Kind: "Widget", Singular: "widget", Schema: object(map[string]interface{}{"spec": tt.spec})}
+			got := c.hints()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("hints = %v, want %v", got, tt.want)
+			}
+			if err := validateExample(c.Example()["spec"], tt.spec, "spec"); err != nil {
+				t.Errorf("example does not validate: %v", err)
+			}
+			for _, h := range got {
+				v := interface{}(c.Example())
+				for _, k := range strings.Split(h.Path, ".") {
+					v = v.(map[string]interface{})[k]
+				}
+				if v != h.Value {
+					t.Errorf("example %s = %v, want %v", h.Path, v, h.Value)
+				}
+			}
+		})
+	}
+}
@@ -4,15 +4,130 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Name is the name of the generated chart.
+const Name = "keycloak-operator"
+
+// CRDChartName is the name of the chart the CRDs are generated into with
+// Options.SplitCRDs.
+const CRDChartName = Name + "-crds"
+
+// Options are the inputs to Generate besides the upstream manifest.
+type Options struct {
+	// CRDs are copied into crds/.
+	CRDs []Source
+
+	// ChartVersion is the version written to Chart.yaml. When empty, the
+	// version of an existing Chart.yaml in the output directory is kept, or
+	// 0.1.0 for a new chart.
+	ChartVersion string
+
+	// Lock, if set, is embedded in the Chart.yaml annotations.
+	Lock *Lock
+
+	// SplitCRDs generates the CRDs as templates of a separate chart,
+	// CRDChartName, in charts/ instead of crds/. The operator chart
+	// depends on it with the condition crds.enabled.
+	SplitCRDs bool
+
+	// Examples, if set, is the directory to write an example custom
+	// resource of every CRD to.
+	Examples string
+
+	// Changelog, if set, is the CHANGELOG.md to prepend an entry to when
+	// the chart version changes. Released dates the entry.
+	Changelog string
+	Released  time.Time
+
+	// Changes are chart-level changes, such as new values, that comparing
+	// the upstream content cannot find. They are recorded with the
+	// detected ones.
+	Changes []Change
+
+	// SignedImages and AttestedImages are patterns of the images (registry
+	// and repository, * as a wildcard) expected to carry a cosign signature
+	// and an SBOM attestation. The SBOM records the expectation per image.
+	SignedImages   []string
+	AttestedImages []string
+}
+
+// chartData is what the chart templates render from: the upstream data plus
+// chart-level inputs.
+type chartData struct {
+	*Upstream
+	ChartVersion string
+	CRDs         []Source
+	CRDInfo      []CRD
+	Lock         *Lock
+	SplitCRDs    bool
+	Changes      []Change
+}
+
 // Generate writes a complete Helm chart to outputDir from parsed upstream data.
-func Generate(u *Upstream, outputDir string, crdFiles []string) error {
-	dirs := []string{
-		filepath.Join(outputDir, "templates"),
-		filepath.Join(outputDir, "crds"),
+func Generate(u *Upstream, outputDir string, opts Options) error {
+	d := chartData{Upstream: u, ChartVersion: opts.ChartVersion, CRDs: opts.CRDs, Lock: opts.Lock, SplitCRDs: opts.SplitCRDs}
+	if len(opts.CRDs) == 0 {
+		return fmt.Errorf("no CRDs given")
+	}
+	crds, err := parseCRDs(opts.CRDs)
+	if err != nil {
+		return err
+	}
+	for _, c := range crds {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("example of CRD %s is not valid: %w", c.Name, err)
+		}
+	}
+	d.CRDInfo = crds
+	if d.ChartVersion == "" {
+		v, err := existingChartVersion(outputDir)
+		if err != nil {
+			return err
+		}
+		d.ChartVersion = v
+	}
+
+	// The chart as it was is what a new version's changes are relative to.
+	previous, err := loadChartState(outputDir)
+	if err != nil {
+		return fmt.Errorf("reading previous chart: %w", err)
+	}
+	bumped := previous != nil && previous.Version != d.ChartVersion
+	if previous != nil && !bumped {
+		d.Changes = previous.Changes
+	}
+	d.Changes = addChanges(d.Changes, opts.Changes)
+
+	// The CRD directory of the mode is owned entirely by the generator. The
+	// other one is left alone, but a chart with both would install the
+	// CRDs twice.
+	crdsDir := filepath.Join(outputDir, "crds")
+	crdChartDir := filepath.Join(outputDir, "charts", CRDChartName)
+	replaced, other := crdsDir, crdChartDir
+	if opts.SplitCRDs {
+		replaced, other = crdChartDir, crdsDir
+	}
+	if _, err := os.Stat(other); err == nil {
+		return fmt.Errorf("%s is from generating with the other CRD layout; remove it first", other)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(replaced); err != nil {
+		return fmt.Errorf("removing %s: %w", replaced, err)
+	}
+
+	dirs := []string{filepath.Join(outputDir, "templates")}
+	if opts.SplitCRDs {
+		dirs = append(dirs, filepath.Join(crdChartDir, "templates"))
+	} else {
+		dirs = append(dirs, crdsDir)
 	}
 	for _, d := range dirs {
 		if err := os.MkdirAll(d, 0o755); err != nil {
@@ -27,11 +142,13 @@ func Generate(u *Upstream, outputDir string, crdFiles []string) error {
 		{"Chart.yaml", chartYAMLTmpl},
 		{"values.yaml", valuesYAMLTmpl},
 		{".helmignore", helmignoreContent},
+		{"README.md", chartReadmeTmpl},
 		{"templates/_helpers.tpl", helpersContent},
 		{"templates/NOTES.txt", notesContent},
+		{"templates/imagepullsecret.yaml", imagePullSecretContent},
 		{"templates/serviceaccount.yaml", serviceAccountTmpl},
 		{"templates/deployment.yaml", deploymentTmpl},
-		{"templates/service.yaml", serviceContent},
+		{"templates/service.yaml", serviceTmpl},
 		{"templates/clusterrole.yaml", clusterRoleTmpl},
 		{"templates/clusterrolebinding.yaml", clusterRoleBindingTmpl},
 		{"templates/role.yaml", roleTmpl},
@@ -49,29 +166,155 @@ func Generate(u *Upstream, outputDir string, crdFiles []string) error {
 			}
 			return strings.Join(lines, "\n")
 		},
+		"yamlString": yamlString,
+		// toYAML renders a scalar for Chart.yaml, which Helm does not
+		// template.
+		"toYAML":       func(s string) (string, error) { return marshalYAML(s) },
+		"crdsExamples": crdsExamples,
+		"exampleYAML":  exampleYAML,
+		"hintPaths":    hintPaths,
+		"crdChartName": func() string {
+			return CRDChartName
+		},
+		"deref": func(p interface{}) interface{} {
+			return reflect.ValueOf(p).Elem().Interface()
+		},
+		"imageValues": func(r ImageRef) (string, error) {
+			return r.valuesYAML(u.AppVersion)
+		},
+		"sbomFile": func() string {
+			return SBOMFile
+		},
 	}
 
 	for _, f := range files {
-		if err := renderFile(filepath.Join(outputDir, f.path), f.tmpl, u, funcMap); err != nil {
+		if err := renderFile(filepath.Join(outputDir, f.path), f.tmpl, d, funcMap); err != nil {
 			return fmt.Errorf("generating %s: %w", f.path, err)
 		}
 	}
 
-	for _, src := range crdFiles {
-		data, err := os.ReadFile(src)
+	// templates/upstream/ is owned entirely by the generator, so resources
+	// that upstream no longer ships are removed.
+	upstreamDir := filepath.Join(outputDir, "templates", "upstream")
+	if err := os.RemoveAll(upstreamDir); err != nil {
+		return fmt.Errorf("removing %s: %w", upstreamDir, err)
+	}
+	if len(u.Resources) > 0 {
+		if err := os.MkdirAll(upstreamDir, 0o755); err != nil {
+			return fmt.Errorf("creating directory %s: %w", upstreamDir, err)
+		}
+	}
+	for _, r := range u.Resources {
+		path := filepath.Join("templates", r.FileName())
+		if err := renderFile(filepath.Join(outputDir, path), upstreamResourceTmpl, r, funcMap); err != nil {
+			return fmt.Errorf("generating %s: %w", path, err)
+		}
+	}
+
+	if opts.SplitCRDs {
+		if err := writeCRDChart(crdChartDir, d, funcMap); err != nil {
+			return err
+		}
+	} else {
+		for _, crd := range d.CRDs {
+			dst := filepath.Join(crdsDir, crd.Name)
+			if err := os.WriteFile(dst, crd.Data, 0o644); err != nil {
+				return fmt.Errorf("writing CRD %s: %w", dst, err)
+			}
+		}
+	}
+
+	if opts.Examples != "" {
+		if err := writeExamples(opts.Examples, d.CRDInfo); err != nil {
+			return fmt.Errorf("writing examples: %w", err)
+		}
+	}
+
+	sbom, err := sbomJSON(d, outputDir, opts)
+	if err != nil {
+		return fmt.Errorf("generating %s: %w", SBOMFile, err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, SBOMFile), sbom, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", SBOMFile, err)
+	}
+
+	if bumped {
+		current, err := loadChartState(outputDir)
 		if err != nil {
-			return fmt.Errorf("reading CRD %s: %w", src, err)
+			return fmt.Errorf("reading generated chart: %w", err)
 		}
-		dst := filepath.Join(outputDir, "crds", filepath.Base(src))
-		if err := os.WriteFile(dst, data, 0o644); err != nil {
-			return fmt.Errorf("writing CRD %s: %w", dst, err)
+		d.Changes = addChanges(diffStates(previous, current), opts.Changes)
+		if err := renderFile(filepath.Join(outputDir, "Chart.yaml"), chartYAMLTmpl, d, funcMap); err != nil {
+			return fmt.Errorf("generating Chart.yaml: %w", err)
+		}
+		if opts.Changelog != "" {
+			if err := prependChangelog(opts.Changelog, d.ChartVersion, u.AppVersion, d.Changes, opts.Released); err != nil {
+				return fmt.Errorf("writing %s: %w", opts.Changelog, err)
+			}
 		}
 	}
 
 	return nil
 }
 
-func renderFile(path, tmplStr string, data *Upstream, funcMap template.FuncMap) error {
+// writeCRDChart writes the CRD chart to dir, one template per CRD.
+func writeCRDChart(dir string, d chartData, funcMap template.FuncMap) error {
+	files := []struct {
+		path string
+		tmpl string
+	}{
+		{"Chart.yaml", crdChartYAMLTmpl},
+		{"values.yaml", crdValuesYAMLContent},
+		{".helmignore", helmignoreContent},
+		{"templates/_helpers.tpl", crdHelpersContent},
+	}
+	for _, f := range files {
+		if err := renderFile(filepath.Join(dir, f.path), f.tmpl, d, funcMap); err != nil {
+			return fmt.Errorf("generating %s/%s: %w", CRDChartName, f.path, err)
+		}
+	}
+	for _, crd := range d.CRDInfo {
+		path := filepath.Join("templates", crd.Name+".yaml")
+		if err := renderFile(filepath.Join(dir, path), crdTmpl, crd, funcMap); err != nil {
+			return fmt.Errorf("generating %s/%s: %w", CRDChartName, path, err)
+		}
+	}
+	return nil
+}
+
+// existingChartVersion returns the version of the Chart.yaml in dir, or 0.1.0
+// if there is none.
+func existingChartVersion(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if os.IsNotExist(err) {
+		return "0.1.0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var meta struct {
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return "", fmt.Errorf("reading %s: %w", filepath.Join(dir, "Chart.yaml"), err)
+	}
+	if meta.Version == "" {
+		return "0.1.0", nil
+	}
+	return meta.Version, nil
+}
+
+// yamlString renders s as a YAML scalar that always reads back as the same
+// string, quoting it only where plain style would change its meaning.
+func yamlString(s string) (string, error) {
+	out, err := marshalYAML(s)
+	if err != nil {
+		return "", err
+	}
+	return escapeHelm(out), nil
+}
+
+func renderFile(path, tmplStr string, data interface{}, funcMap template.FuncMap) error {
 	tmpl, err := template.New("").Delims("[[", "]]").Funcs(funcMap).Parse(tmplStr)
 	if err != nil {
 		return fmt.Errorf("parsing template: %w", err)
@@ -0,0 +1,161 @@
+package chart
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ImageRef is an OCI image reference split into its parts. Registry is empty
+// when the reference names none, and Tag or Digest may be empty when the
+// reference does not pin one.
+type ImageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+var (
+	tagRe        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRe     = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[A-Fa-f0-9]{32,}$`)
+	pathPartRe   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	registryHost = regexp.MustCompile(`^(?:[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*|\[[0-9A-Fa-f:]+\])(?::[0-9]+)?$`)
+)
+
+// ParseImageRef parses an image reference of the form
+// [registry/]repository[:tag][@digest]. The first path component is taken as
+// the registry when it contains a '.' or ':' or is "localhost", as the
+// container runtimes do.
+func ParseImageRef(s string) (ImageRef, error) {
+	var ref ImageRef
+	rest := s
+	if i := strings.Index(rest, "@"); i != -1 {
+		rest, ref.Digest = rest[:i], rest[i+1:]
+		if !digestRe.MatchString(ref.Digest) {
+			return ImageRef{}, fmt.Errorf("image %q: invalid digest %q", s, ref.Digest)
+		}
+	}
+
+	// A tag follows the last ':' after the last '/'; a ':' before that
+	// belongs to a registry port.
+	if i := strings.LastIndex(rest, ":"); i != -1 && i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+		if !tagRe.MatchString(ref.Tag) {
+			return ImageRef{}, fmt.Errorf("image %q: invalid tag %q", s, ref.Tag)
+		}
+	}
+
+	if i := strings.Index(rest, "/"); i != -1 && isRegistry(rest[:i]) {
+		ref.Registry, rest = rest[:i], rest[i+1:]
+		if !registryHost.MatchString(ref.Registry) {
+			return ImageRef{}, fmt.Errorf("image %q: invalid registry %q", s, ref.Registry)
+		}
+	}
+
+	ref.Repository = rest
+	if rest == "" {
+		return ImageRef{}, fmt.Errorf("image %q: no repository", s)
+	}
+	for _, part := range strings.Split(rest, "/") {
+		if !pathPartRe.MatchString(part) {
+			return ImageRef{}, fmt.Errorf("image %q: invalid repository %q", s, rest)
+		}
+	}
+	return ref, nil
+}
+
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// Name returns the registry and repository without tag or digest.
+func (r ImageRef) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the reference in its canonical form.
+func (r ImageRef) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// valuesYAML renders r as an image values block: registry, repository, tag and
+// digest. A tag equal to appVersion is left empty so that it follows the chart.
+func (r ImageRef) valuesYAML(appVersion string) (string, error) {
+	var b strings.Builder
+	fields := []struct{ key, value, comment string }{
+		{"registry", r.Registry, ""},
+		{"repository", r.Repository, ""},
+		{"tag", r.Tag, ""},
+		{"digest", r.Digest, "Pulls by digest instead of tag when set"},
+	}
+	if r.Tag == appVersion {
+		fields[2].value, fields[2].comment = "", "Defaults to appVersion"
+	}
+	for _, f := range fields {
+		if f.comment != "" {
+			fmt.Fprintf(&b, "# %s\n", f.comment)
+		}
+		value, err := yamlString(f.value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s: %s\n", f.key, value)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// PinDigests resolves the tag of every image the chart deploys to a digest,
+// which then becomes the default in values.yaml and the Chart.yaml images
+// annotation. Images that already carry a digest are left alone.
+func (u *Upstream) PinDigests(resolve func(ImageRef) (string, error)) error {
+	digests := make(map[ImageRef]string)
+	pin := func(ref *ImageRef) error {
+		if ref.Digest != "" || ref.Repository == "" {
+			return nil
+		}
+		digest, ok := digests[*ref]
+		if !ok {
+			var err error
+			if digest, err = resolve(*ref); err != nil {
+				return fmt.Errorf("resolving %s: %w", ref, err)
+			}
+			if !digestRe.MatchString(digest) {
+				return fmt.Errorf("resolving %s: invalid digest %q", ref, digest)
+			}
+			digests[*ref] = digest
+		}
+		ref.Digest = digest
+		return nil
+	}
+
+	refs := []*ImageRef{&u.OperatorImage, &u.KeycloakImage}
+	for i := range u.RelatedImages {
+		refs = append(refs, &u.RelatedImages[i].Image)
+	}
+	for i := range u.Workloads {
+		w := &u.Workloads[i]
+		for j := range w.InitContainers {
+			refs = append(refs, &w.InitContainers[j].Image)
+		}
+		for j := range w.Containers {
+			refs = append(refs, &w.Containers[j].Image)
+		}
+	}
+	for _, ref := range refs {
+		if err := pin(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package chart
+
+import "testing"
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ImageRef
+	}{
+		{"keycloak/keycloak-operator", ImageRef{Repository: "keycloak/keycloak-operator"}},
+		{"quay.io/keycloak/keycloak-operator:26.5.3", ImageRef{Registry: "quay.io", Repository: "keycloak/keycloak-operator", Tag: "26.5.3"}},
+		{"quay.io/keycloak/keycloak-operator@" + testDigest, ImageRef{Registry: "quay.io", Repository: "keycloak/keycloak-operator", Digest: testDigest}},
+		{"quay.io/keycloak/keycloak-operator:26.5.3@" + testDigest, ImageRef{Registry: "quay.io", Repository: "keycloak/keycloak-operator", Tag: "26.5.3", Digest: testDigest}},
+		{"localhost:5000/keycloak-operator@" + testDigest, ImageRef{Registry: "localhost:5000", Repository: "keycloak-operator", Digest: testDigest}},
+		{"localhost/keycloak-operator:nightly", ImageRef{Registry: "localhost", Repository: "keycloak-operator", Tag: "nightly"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseImageRef(tt.in)
+		if err != nil {
+			t.Errorf("ParseImageRef(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseImageRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.in {
+			t.Errorf("ParseImageRef(%q).String() = %q", tt.in, s)
+		}
+	}
+}
+
+func TestParseImageRefInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"quay.io/keycloak/keycloak-operator@sha256:xyz",
+		"quay.io/keycloak/keycloak-operator@" + testDigest[:20],
+		"quay.io/keycloak/keycloak-operator:",
+		"quay.io/Keycloak/keycloak-operator:26.5.3",
+		"quay.io/",
+	} {
+		if ref, err := ParseImageRef(s); err == nil {
+			t.Errorf("ParseImageRef(%q) = %+v, want an error", s, ref)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package chart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Values is a chart's values tree, as read from values.yaml.
+type Values map[string]interface{}
+
+// LoadValues reads the values.yaml of a generated chart and merges each file
+// in valueFiles over it, in order, as helm install -f does.
+func LoadValues(chartDir string, valueFiles []string) (Values, error) {
+	values := Values{}
+	for _, path := range append([]string{filepath.Join(chartDir, "values.yaml")}, valueFiles...) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var override map[string]interface{}
+		if err := yaml.Unmarshal(data, &override); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		mergeValues(values, override)
+	}
+	return values, nil
+}
+
+// mergeValues merges src into dst. Maps merge key by key, anything else
+// replaces, and a null removes the key.
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// ChartAppVersion reads appVersion from a chart's Chart.yaml.
+func ChartAppVersion(chartDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return "", err
+	}
+	var meta struct {
+		AppVersion string `yaml:"appVersion"`
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return "", fmt.Errorf("Chart.yaml: %w", err)
+	}
+	if meta.AppVersion == "" {
+		return "", fmt.Errorf("Chart.yaml has no appVersion")
+	}
+	return meta.AppVersion, nil
+}
+
+// ResolveImages returns every image the chart deploys with the given values,
+// resolved the way the keycloak-operator.image helper renders them: the
+// operator, the Keycloak server, the other related images and the remaining
+// containers, each group in values key order.
+func (v Values) ResolveImages(appVersion string) ([]ChartImage, error) {
+	var out []ChartImage
+	add := func(name, path string, block interface{}) error {
+		m, ok := block.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: not a mapping", path)
+		}
+		ref, err := v.resolveImage(m, appVersion)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		out = append(out, ChartImage{Name: name, Values: path, Image: ref})
+		return nil
+	}
+
+	if err := add("keycloak-operator", "image", v["image"]); err != nil {
+		return nil, err
+	}
+	if block, ok := v["keycloakImage"]; ok {
+		if err := add("keycloak", "keycloakImage", block); err != nil {
+			return nil, err
+		}
+	}
+	related, _ := v["relatedImages"].(map[string]interface{})
+	for _, key := range sortedKeys(related) {
+		if err := add(kebabCase(key), "relatedImages."+key, related[key]); err != nil {
+			return nil, err
+		}
+	}
+	containers, _ := v["containers"].(map[string]interface{})
+	for _, key := range sortedKeys(containers) {
+		c, _ := containers[key].(map[string]interface{})
+		if err := add(kebabCase(key), "containers."+key+".image", c["image"]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// resolveImage mirrors the keycloak-operator.image helper.
+func (v Values) resolveImage(block map[string]interface{}, appVersion string) (ImageRef, error) {
+	s := func(key string) string {
+		return scalarString(block[key])
+	}
+	ref := ImageRef{Registry: s("registry"), Repository: s("repository"), Tag: s("tag"), Digest: s("digest")}
+	if ref.Repository == "" {
+		return ImageRef{}, fmt.Errorf("no repository")
+	}
+	if i := strings.Index(ref.Repository, "/"); i != -1 && isRegistry(ref.Repository[:i]) {
+		ref.Registry, ref.Repository = ref.Repository[:i], ref.Repository[i+1:]
+	}
+	if global, ok := v["global"].(map[string]interface{}); ok {
+		if registry := scalarString(global["imageRegistry"]); registry != "" {
+			ref.Registry = registry
+		}
+	}
+	if ref.Digest != "" {
+		ref.Tag = ""
+	} else if ref.Tag == "" {
+		ref.Tag = appVersion
+	}
+	return ParseImageRef(ref.String())
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var upperRe = regexp.MustCompile(`[A-Z]`)
+
+// kebabCase turns a values key such as dbUpdater back into db-updater.
+func kebabCase(key string) string {
+	return upperRe.ReplaceAllStringFunc(key, func(s string) string {
+		return "-" + strings.ToLower(s)
+	})
+}
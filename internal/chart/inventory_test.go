@@ -0,0 +1,173 @@
+package chart
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseValues(t *testing.T, data string) Values {
+	t.Helper()
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestResolveImages(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0", 64)
+	tests := []struct {
+		name   string
+		values string
+		want   []string // values path=image
+	}{
+		{
+			"tag defaults to appVersion",
+			"image:\n  registry: quay.io\n  repository: keycloak/keycloak-operator\n  tag: \"\"\n",
+			[]string{"image=quay.io/keycloak/keycloak-operator:26.5.3"},
+		},
+		{
+			"digest over tag",
+			"image:\n  registry: quay.io\n  repository: keycloak/keycloak-operator\n  tag: \"26.0.0\"\n  digest: " + digest + "\n",
+			[]string{"image=quay.io/keycloak/keycloak-operator@" + digest},
+		},
+		{
+			"registry in repository",
+			"image:\n  registry: \"\"\n  repository: quay.io/keycloak/keycloak-operator\n  tag: \"26.0.0\"\n",
+			[]string{"image=quay.io/keycloak/keycloak-operator:26.0.0"},
+		},
+		{
+			"registry with port in repository",
+			"image:\n  registry: other.example.com\n  repository: localhost:5000/keycloak-operator\n",
+			[]string{"image=localhost:5000/keycloak-operator:26.5.3"},
+		},
+		{
+			"no registry",
+			"image:\n  repository: keycloak/keycloak-operator\n",
+			[]string{"image=keycloak/keycloak-operator:26.5.3"},
+		},
+		{
+			"global.imageRegistry",
+			"global:\n  imageRegistry: mirror.example.com\n" +
+				"image:\n  registry: quay.io\n  repository: keycloak/keycloak-operator\n" +
+				"keycloakImage:\n  repository: quay.io/keycloak/keycloak\n  digest: " + digest + "\n",
+			[]string{
+				"image=mirror.example.com/keycloak/keycloak-operator:26.5.3",
+				"keycloakImage=mirror.example.com/keycloak/keycloak@" + digest,
+			},
+		},
+		{
+			"order",
+			"image:\n  repository: keycloak/keycloak-operator\n" +
+				"containers:\n  sidecar:\n    image:\n      repository: b\n      tag: \"1\"\n" +
+				"relatedImages:\n  dbUpdater:\n    repository: c\n    tag: \"1\"\n  cache:\n    repository: d\n    tag: \"1\"\n" +
+				"keycloakImage:\n  repository: e\n",
+			[]string{
+				"image=keycloak/keycloak-operator:26.5.3",
+				"keycloakImage=e:26.5.3",
+				"relatedImages.cache=d:1",
+				"relatedImages.dbUpdater=c:1",
+				"containers.sidecar.image=b:1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := parseValues(t, tt.values).ResolveImages("26.5.3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, img := range images {
+				got = append(got, img.Values+"="+img.Image.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("images = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveImagesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		values string
+		want   string
+	}{
+		{"no image", "keycloakImage:\n  repository: e\n", "image: not a mapping"},
+		{"no repository", "image:\n  tag: \"1\"\n", "image: no repository"},
+		{"bad container", "image:\n  repository: a\ncontainers:\n  x:\n    image: b:1\n", "containers.x.image: not a mapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseValues(t, tt.values).ResolveImages("26.5.3")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ResolveImages error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadValues(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("values.yaml", "global:\n  imageRegistry: \"\"\nimage:\n  registry: quay.io\n  repository: keycloak/keycloak-operator\n  tag: \"\"\n  digest: \"\"\nresources:\n  limits:\n    cpu: 500m\n")
+	first := write("first.yaml", "global:\n  imageRegistry: mirror.example.com\nimage:\n  tag: \"1.0\"\nresources:\n  limits: null\n")
+	second := write("second.yaml", "image:\n  tag: \"2.0\"\n  registry: null\n")
+
+	got, err := LoadValues(dir, []string{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Values{
+		"global": map[string]interface{}{"imageRegistry": "mirror.example.com"},
+		// Maps merge key by key, later files win and a null removes a key.
+		"image":     map[string]interface{}{"repository": "keycloak/keycloak-operator", "tag": "2.0", "digest": ""},
+		"resources": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+// imageHelper is the keycloak-operator.image helper resolveImage mirrors.
+// When this test fails, change resolveImage to match the helper, then update
+// the copy here.
+const imageHelper = `{{- define "keycloak-operator.image" -}}
+{{- $registry := .image.registry }}
+{{- $repository := .image.repository }}
+{{- $host := regexFind "^[^/]+/" $repository | trimSuffix "/" }}
+{{- if or (contains "." $host) (contains ":" $host) (eq $host "localhost") }}
+{{- $registry = $host }}
+{{- $repository = trimPrefix (printf "%s/" $host) $repository }}
+{{- end }}
+{{- with .context.Values.global.imageRegistry }}
+{{- $registry = . }}
+{{- end }}
+{{- if $registry }}
+{{- $repository = printf "%s/%s" $registry $repository }}
+{{- end }}
+{{- if .image.digest }}
+{{- printf "%s@%s" $repository .image.digest }}
+{{- else }}
+{{- printf "%s:%s" $repository (.image.tag | default .context.Chart.AppVersion) }}
+{{- end }}
+{{- end }}
+`
+
+func TestImageHelperPinned(t *testing.T) {
+	if !strings.Contains(helpersContent, imageHelper) {
+		t.Error("the keycloak-operator.image helper changed; update resolveImage to match")
+	}
+}
@@ -0,0 +1,108 @@
+package chart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lock records the upstream release and the exact input files a chart was
+// generated from. It is written to upstream.lock and embedded in Chart.yaml.
+type Lock struct {
+	Version string     `yaml:"version"`
+	Files   []LockFile `yaml:"files"`
+}
+
+// LockFile is an input file in a Lock: the URL it was fetched from, or the
+// local path it was read from.
+type LockFile struct {
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url,omitempty"`
+	Path   string `yaml:"path,omitempty"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// NewLock returns the lock for the given upstream version and input files.
+func NewLock(version string, sources ...Source) Lock {
+	l := Lock{Version: version}
+	for _, s := range sources {
+		sum := sha256.Sum256(s.Data)
+		l.Files = append(l.Files, LockFile{Name: s.Name, URL: s.URL, Path: s.Path, SHA256: hex.EncodeToString(sum[:])})
+	}
+	return l
+}
+
+// LoadLock reads a lock file. It returns nil if there is none.
+func LoadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l Lock
+	if err := yaml.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// Write writes the lock file.
+func (l Lock) Write(path string) error {
+	body, err := l.YAML()
+	if err != nil {
+		return err
+	}
+	header := "# Upstream release and input files the chart was generated from.\n" +
+		"# Written by cmd/generate; update with --update-lock.\n"
+	return os.WriteFile(path, []byte(header+body+"\n"), 0o644)
+}
+
+// YAML returns the lock as YAML, without a trailing newline.
+func (l Lock) YAML() (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(l); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Mismatches lists how got differs from the lock: a different version, and
+// every file whose checksum differs or that only one side has.
+func (l Lock) Mismatches(got Lock) []string {
+	var out []string
+	if l.Version != got.Version {
+		out = append(out, fmt.Sprintf("version %s, locked %s", got.Version, l.Version))
+	}
+	locked := make(map[string]LockFile)
+	for _, f := range l.Files {
+		locked[f.Name] = f
+	}
+	for _, f := range got.Files {
+		want, ok := locked[f.Name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("%s is not locked", f.Name))
+		case want.SHA256 != f.SHA256:
+			out = append(out, fmt.Sprintf("%s has sha256 %s, locked %s", f.Name, f.SHA256, want.SHA256))
+		}
+		delete(locked, f.Name)
+	}
+	for _, f := range l.Files {
+		if _, ok := locked[f.Name]; ok {
+			out = append(out, fmt.Sprintf("%s is locked but not an input", f.Name))
+		}
+	}
+	return out
+}
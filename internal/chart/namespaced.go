@@ -0,0 +1,210 @@
+package chart
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NamespacedRole is a ClusterRole that RoleBindings reference, narrowed to
+// the rules a Role can grant. With clusterScoped.enabled=false it is
+// rendered as a Role of the same name, and the RoleBindings refer to it.
+type NamespacedRole struct {
+	Suffix    string
+	RulesYAML string // empty if no rule is left
+
+	// Skipped describes the rules only a ClusterRole can grant, e.g. "get
+	// on nodes".
+	Skipped []string
+}
+
+// ClusterGrant is a ClusterRole that ClusterRoleBindings bind, which a
+// cluster admin has to set up when clusterScoped.enabled=false.
+type ClusterGrant struct {
+	BindingSuffix string
+	RoleSuffix    string
+	RoleName      string   // a built-in ClusterRole, or empty
+	Rules         []string // described, for a managed ClusterRole
+}
+
+// clusterScopedResources lists the cluster-scoped resources a rule may name,
+// as group/resource; group/* covers a whole group. Anything else, and any
+// CRD of Namespaced scope, is taken to be namespaced.
+var clusterScopedResources = map[string]bool{
+	"/componentstatuses": true,
+	"/namespaces":        true,
+	"/nodes":             true,
+	"/persistentvolumes": true,
+	"admissionregistration.k8s.io/mutatingwebhookconfigurations":     true,
+	"admissionregistration.k8s.io/validatingadmissionpolicies":       true,
+	"admissionregistration.k8s.io/validatingadmissionpolicybindings": true,
+	"admissionregistration.k8s.io/validatingwebhookconfigurations":   true,
+	"apiextensions.k8s.io/customresourcedefinitions":                 true,
+	"apiregistration.k8s.io/apiservices":                             true,
+	"authentication.k8s.io/tokenreviews":                             true,
+	"authorization.k8s.io/selfsubjectaccessreviews":                  true,
+	"authorization.k8s.io/selfsubjectrulesreviews":                   true,
+	"authorization.k8s.io/subjectaccessreviews":                      true,
+	"certificates.k8s.io/certificatesigningrequests":                 true,
+	"config.openshift.io/*":                                          true,
+	"flowcontrol.apiserver.k8s.io/flowschemas":                       true,
+	"flowcontrol.apiserver.k8s.io/prioritylevelconfigurations":       true,
+	"networking.k8s.io/ingressclasses":                               true,
+	"node.k8s.io/runtimeclasses":                                     true,
+	"rbac.authorization.k8s.io/clusterrolebindings":                  true,
+	"rbac.authorization.k8s.io/clusterroles":                         true,
+	"scheduling.k8s.io/priorityclasses":                              true,
+	"storage.k8s.io/csidrivers":                                      true,
+	"storage.k8s.io/csinodes":                                        true,
+	"storage.k8s.io/storageclasses":                                  true,
+	"storage.k8s.io/volumeattachments":                               true,
+}
+
+// clusterScoped reports whether a resource, possibly with a subresource,
+// only exists cluster-wide.
+func (d chartData) clusterScoped(group, resource string) bool {
+	resource, _, _ = strings.Cut(resource, "/")
+	for _, c := range d.CRDInfo {
+		if c.Group == group && c.Plural == resource {
+			return c.Scope == "Cluster"
+		}
+	}
+	return clusterScopedResources[group+"/"+resource] || clusterScopedResources[group+"/*"]
+}
+
+// NamespacedRoles narrows every managed ClusterRole that a RoleBinding
+// references.
+func (d chartData) NamespacedRoles() ([]NamespacedRole, error) {
+	referenced := make(map[string]bool)
+	for _, b := range d.RBAC.RoleBindings {
+		if b.RoleRefKind == "ClusterRole" && !b.IsBuiltinRole {
+			referenced[b.RoleSuffix] = true
+		}
+	}
+	var roles []NamespacedRole
+	for _, r := range d.RBAC.ClusterRoles {
+		if !referenced[r.Suffix] {
+			continue
+		}
+		role := NamespacedRole{Suffix: r.Suffix}
+		var kept []interface{}
+		for _, rule := range r.Rules {
+			k, skipped := d.narrowRule(rule)
+			kept = append(kept, k...)
+			role.Skipped = append(role.Skipped, skipped...)
+		}
+		if len(kept) > 0 {
+			y, err := marshalYAML(kept)
+			if err != nil {
+				return nil, fmt.Errorf("marshaling rules of %s: %w", r.OriginalName, err)
+			}
+			role.RulesYAML = y
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// NamespacedRole returns the NamespacedRole of a ClusterRole suffix, or nil.
+func (d chartData) NamespacedRole(suffix string) (*NamespacedRole, error) {
+	roles, err := d.NamespacedRoles()
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Suffix == suffix {
+			return &roles[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// narrowRule splits a policy rule into the rules a Role can grant and the
+// descriptions of those it cannot. A rule that names no cluster-scoped
+// resource is kept as is; otherwise it is split per API group.
+func (d chartData) narrowRule(rule interface{}) (kept []interface{}, skipped []string) {
+	m, _ := rule.(map[string]interface{})
+	verbs := strings.Join(stringList(m["verbs"]), ", ")
+	if urls := stringList(m["nonResourceURLs"]); len(urls) > 0 {
+		return nil, []string{fmt.Sprintf("%s on %s", verbs, strings.Join(urls, ", "))}
+	}
+
+	groups := stringList(m["apiGroups"])
+	resources := stringList(m["resources"])
+	split := false
+	for _, g := range groups {
+		for _, r := range resources {
+			if d.clusterScoped(g, r) {
+				split = true
+			}
+		}
+	}
+	if !split {
+		return []interface{}{rule}, nil
+	}
+
+	for _, g := range groups {
+		var namespaced, cluster []string
+		for _, r := range resources {
+			if d.clusterScoped(g, r) {
+				cluster = append(cluster, qualifiedResource(g, r))
+			} else {
+				namespaced = append(namespaced, r)
+			}
+		}
+		if len(cluster) > 0 {
+			skipped = append(skipped, fmt.Sprintf("%s on %s", verbs, strings.Join(cluster, ", ")))
+		}
+		if len(namespaced) == 0 {
+			continue
+		}
+		narrowed := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			narrowed[k] = v
+		}
+		narrowed["apiGroups"] = []interface{}{g}
+		list := make([]interface{}, len(namespaced))
+		for i, r := range namespaced {
+			list[i] = r
+		}
+		narrowed["resources"] = list
+		kept = append(kept, narrowed)
+	}
+	return kept, skipped
+}
+
+// ClusterGrants lists what the ClusterRoleBindings grant.
+func (d chartData) ClusterGrants() []ClusterGrant {
+	roles := make(map[string]RBACRole, len(d.RBAC.ClusterRoles))
+	for _, r := range d.RBAC.ClusterRoles {
+		roles[r.Suffix] = r
+	}
+	var grants []ClusterGrant
+	for _, b := range d.RBAC.ClusterRoleBindings {
+		g := ClusterGrant{BindingSuffix: b.Suffix, RoleSuffix: b.RoleSuffix}
+		if b.IsBuiltinRole {
+			g.RoleName = b.RoleRefName
+		} else {
+			for _, rule := range roles[b.RoleSuffix].Rules {
+				g.Rules = append(g.Rules, describeRule(rule))
+			}
+		}
+		grants = append(grants, g)
+	}
+	return grants
+}
+
+// describeRule describes a policy rule as "<verbs> on <resources>".
+func describeRule(rule interface{}) string {
+	m, _ := rule.(map[string]interface{})
+	targets := stringList(m["nonResourceURLs"])
+	groups := stringList(m["apiGroups"])
+	if len(groups) == 0 {
+		groups = []string{""}
+	}
+	for _, g := range groups {
+		for _, r := range stringList(m["resources"]) {
+			targets = append(targets, qualifiedResource(g, r))
+		}
+	}
+	return fmt.Sprintf("%s on %s", strings.Join(stringList(m["verbs"]), ", "), strings.Join(targets, ", "))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -13,15 +14,26 @@ type rawResource struct {
 	Kind string
 	Name string
 	Raw  map[string]interface{}
+	Used fieldTracker
 }
 
-// Parse reads a multi-document YAML manifest and extracts chart data.
-func Parse(data []byte) (*Upstream, error) {
+// useMetadata marks the fields every chart template replaces with its own:
+// the name gets the chart fullname, labels the chart labels, and the
+// namespace the release namespace.
+func (r rawResource) useMetadata() {
+	r.Used.use("$.apiVersion", "$.kind", "$.metadata.name", "$.metadata.namespace", "$.metadata.labels")
+}
+
+// Parse reads a multi-document YAML manifest and extracts chart data. The
+// appVersion is the operator image's tag or, for an image pinned by digest
+// only, version: the upstream release the manifest is from, if known.
+// Resources that ignored acknowledges as a whole are not passed through.
+func Parse(data []byte, version string, ignored IgnoreList) (*Upstream, error) {
 	resources, err := parseDocuments(data)
 	if err != nil {
 		return nil, err
 	}
-	return buildUpstream(resources)
+	return buildUpstream(resources, version, ignored)
 }
 
 func parseDocuments(data []byte) ([]rawResource, error) {
@@ -44,14 +56,16 @@ func parseDocuments(data []byte) ([]rawResource, error) {
 		metadata, _ := raw["metadata"].(map[string]interface{})
 		name, _ := metadata["name"].(string)
 
-		resources = append(resources, rawResource{Kind: kind, Name: name, Raw: raw})
+		resources = append(resources, rawResource{Kind: kind, Name: name, Raw: raw, Used: fieldTracker{}})
 	}
 	return resources, nil
 }
 
-func buildUpstream(resources []rawResource) (*Upstream, error) {
+func buildUpstream(resources []rawResource, version string, ignored IgnoreList) (*Upstream, error) {
 	u := &Upstream{}
 	managedRoles := make(map[string]string) // original name → suffix
+	var passthrough []rawResource
+	hasService := false
 
 	// First pass: collect roles, deployment, service
 	for _, r := range resources {
@@ -73,16 +87,59 @@ func buildUpstream(resources []rawResource) (*Upstream, error) {
 			managedRoles[r.Name] = role.Suffix
 
 		case "Deployment":
-			if err := u.parseDeployment(r); err != nil {
+			w, err := u.parseDeployment(r, len(u.Workloads) == 0)
+			if err != nil {
 				return nil, fmt.Errorf("parsing Deployment %q: %w", r.Name, err)
 			}
+			u.Workloads = append(u.Workloads, w)
+
+		case "StatefulSet", "DaemonSet", "ReplicaSet", "Job", "CronJob", "Pod":
+			return nil, fmt.Errorf("%s %q: only Deployments can be mapped to chart templates", r.Kind, r.Name)
 
 		case "Service":
-			u.parseService(r)
+			// The first Service is the chart's; any other one is passed
+			// through.
+			if hasService {
+				passthrough = append(passthrough, r)
+				continue
+			}
+			if err := u.parseService(r); err != nil {
+				return nil, fmt.Errorf("parsing Service %q: %w", r.Name, err)
+			}
+			hasService = true
+
+		case "ServiceAccount":
+			r.useMetadata() // rendered by serviceAccountTmpl
+
+		case "ClusterRoleBinding", "RoleBinding":
+			// third pass
+
+		case "Namespace", "CustomResourceDefinition":
+			// The namespace comes from the release and CRDs from --crd;
+			// these are reported as dropped.
+
+		default:
+			// second pass
+			passthrough = append(passthrough, r)
+		}
+	}
+
+	// Second pass: passthrough resources, whose selectors may match the pods
+	// of any Deployment. A resource acknowledged in the ignore list as a
+	// whole is left out and reported as dropped.
+	for _, r := range passthrough {
+		if ignored.Excludes(r.Kind + "/" + r.Name) {
+			continue
+		}
+		res, err := u.parseResource(r, clusterScopedKinds[r.Kind])
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s %q: %w", r.Kind, r.Name, err)
 		}
+		u.Resources = append(u.Resources, res)
 	}
+	u.renameReferences()
 
-	// Second pass: process bindings (roles must be known first)
+	// Third pass: process bindings (roles must be known first)
 	for _, r := range resources {
 		switch r.Kind {
 		case "ClusterRoleBinding":
@@ -92,13 +149,63 @@ func buildUpstream(resources []rawResource) (*Upstream, error) {
 		}
 	}
 
+	if len(u.Workloads) == 0 {
+		return nil, fmt.Errorf("no Deployment found")
+	}
 	if u.AppVersion == "" {
-		return nil, fmt.Errorf("no Deployment found or image tag missing")
+		if version == "" {
+			return nil, fmt.Errorf("operator image %s has no tag to take appVersion from, and no upstream version is given", u.OperatorImage)
+		}
+		u.AppVersion = version
+	}
+
+	if err := u.checkValuesKeys(); err != nil {
+		return nil, err
+	}
+	u.mapServiceTargetPorts()
+
+	for _, r := range resources {
+		u.Drops = append(u.Drops, r.drops()...)
 	}
 
 	return u, nil
 }
 
+// checkValuesKeys rejects workloads or containers whose names collapse to the
+// same values key, since one would silently override the other.
+func (u *Upstream) checkValuesKeys() error {
+	workloads := make(map[string]string)
+	for _, w := range u.ExtraWorkloads() {
+		if w.ValuesKey == "" {
+			return fmt.Errorf("Deployment %q: cannot derive a values key", w.OriginalName)
+		}
+		if prev, ok := workloads[w.ValuesKey]; ok {
+			return fmt.Errorf("Deployments %q and %q both map to workloads.%s", prev, w.OriginalName, w.ValuesKey)
+		}
+		workloads[w.ValuesKey] = w.OriginalName
+	}
+
+	resources := make(map[string]string)
+	for _, r := range u.Resources {
+		if prev, ok := resources[r.ValuesKey]; ok {
+			return fmt.Errorf("%s and %s %q both map to upstream.%s", prev, r.Kind, r.OriginalName, r.ValuesKey)
+		}
+		resources[r.ValuesKey] = fmt.Sprintf("%s %q", r.Kind, r.OriginalName)
+	}
+
+	containers := make(map[string]string)
+	for _, c := range u.ExtraContainers() {
+		if c.ValuesKey == "" {
+			return fmt.Errorf("container %q: cannot derive a values key", c.Name)
+		}
+		if prev, ok := containers[c.ValuesKey]; ok {
+			return fmt.Errorf("containers %q and %q both map to containers.%s", prev, c.Name, c.ValuesKey)
+		}
+		containers[c.ValuesKey] = c.Name
+	}
+	return nil
+}
+
 // deriveSuffix maps upstream resource names to short Helm template suffixes.
 func deriveSuffix(name string) string {
 	replacements := []struct{ prefix, replacement string }{
@@ -120,19 +227,105 @@ func parseRBACRole(r rawResource) (RBACRole, error) {
 	if !ok {
 		return RBACRole{}, fmt.Errorf("no rules found")
 	}
+	r.useMetadata()
+	r.Used.use("$.rules")
 
 	rulesYAML, err := marshalYAML(rules)
 	if err != nil {
 		return RBACRole{}, fmt.Errorf("marshaling rules: %w", err)
 	}
+	ruleList, _ := rules.([]interface{})
 
 	return RBACRole{
 		OriginalName: r.Name,
 		Suffix:       deriveSuffix(r.Name),
 		RulesYAML:    rulesYAML,
+		Rules:        ruleList,
 	}, nil
 }
 
+// clusterScopedKinds lists the cluster-scoped kinds a passthrough resource
+// may have. Any other kind is rendered as namespaced; a cluster-scoped kind
+// missing here is excluded through the ignore list until it is added.
+var clusterScopedKinds = map[string]bool{
+	"APIService":                       true,
+	"CSIDriver":                        true,
+	"CertificateSigningRequest":        true,
+	"ClusterIssuer":                    true,
+	"FlowSchema":                       true,
+	"IngressClass":                     true,
+	"MutatingWebhookConfiguration":     true,
+	"PersistentVolume":                 true,
+	"PriorityClass":                    true,
+	"PriorityLevelConfiguration":       true,
+	"RuntimeClass":                     true,
+	"StorageClass":                     true,
+	"ValidatingAdmissionPolicy":        true,
+	"ValidatingAdmissionPolicyBinding": true,
+	"ValidatingWebhookConfiguration":   true,
+	"VolumeAttachment":                 true,
+	"VolumeSnapshotClass":              true,
+}
+
+// resourceSuffix is deriveSuffix for passthrough resources, except that one
+// named after the operator itself gets no suffix, like the Deployment.
+func resourceSuffix(name string) string {
+	if name == Name {
+		return ""
+	}
+	return deriveSuffix(name)
+}
+
+func (u *Upstream) parseResource(r rawResource, clusterScoped bool) (Resource, error) {
+	apiVersion, _ := r.Raw["apiVersion"].(string)
+	if apiVersion == "" || r.Kind == "" || r.Name == "" {
+		return Resource{}, fmt.Errorf("apiVersion, kind and metadata.name are required")
+	}
+
+	res := Resource{
+		APIVersion:    apiVersion,
+		Kind:          r.Kind,
+		OriginalName:  r.Name,
+		Suffix:        resourceSuffix(r.Name),
+		ClusterScoped: clusterScoped,
+	}
+	res.ValuesKey = valuesKey(r.Kind + "-" + res.Suffix)
+
+	metadata, _ := r.Raw["metadata"].(map[string]interface{})
+	if annotations, ok := metadata["annotations"]; ok {
+		y, err := marshalYAML(annotations)
+		if err != nil {
+			return Resource{}, fmt.Errorf("marshaling annotations: %w", err)
+		}
+		res.AnnotationsYAML = escapeHelm(y)
+	}
+
+	body := make(map[string]interface{})
+	for k, v := range r.Raw {
+		if k != "apiVersion" && k != "kind" && k != "metadata" {
+			body[k] = v
+		}
+	}
+	if len(body) > 0 {
+		selectors := u.replaceSelectors(body)
+		y, err := marshalYAML(body)
+		if err != nil {
+			return Resource{}, fmt.Errorf("marshaling resource: %w", err)
+		}
+		res.BodyYAML = renderSelectors(escapeHelm(y), selectors)
+	}
+
+	// Everything except the namespace, which is dropped, and the labels,
+	// which are replaced by the chart labels, is rendered.
+	r.Used.use("$")
+	return res, nil
+}
+
+// escapeHelm keeps Helm from evaluating {{ in verbatim upstream content.
+func escapeHelm(s string) string {
+	return strings.ReplaceAll(s, "{{", `{{ "{{" }}`)
+}
+
 func parseRBACBinding(r rawResource, managedRoles map[string]string) RBACBinding {
 	roleRef, _ := r.Raw["roleRef"].(map[string]interface{})
 	roleRefKind, _ := roleRef["kind"].(string)
@@ -140,6 +333,10 @@ func parseRBACBinding(r rawResource, managedRoles map[string]string) RBACBinding
 
 	roleSuffix, isManaged := managedRoles[roleRefName]
 
+	// Subjects are replaced by the chart's ServiceAccount.
+	r.useMetadata()
+	r.Used.use("$.roleRef", "$.subjects")
+
 	return RBACBinding{
 		OriginalName:  r.Name,
 		Suffix:        deriveSuffix(r.Name),
@@ -150,83 +347,435 @@ func parseRBACBinding(r rawResource, managedRoles map[string]string) RBACBinding
 	}
 }
 
-func (u *Upstream) parseDeployment(r rawResource) error {
+func (u *Upstream) parseDeployment(r rawResource, primary bool) (Workload, error) {
 	spec, _ := r.Raw["spec"].(map[string]interface{})
-	u.Deployment.Replicas = intFromMap(spec, "replicas")
+	w := Workload{
+		OriginalName: r.Name,
+		Suffix:       deriveSuffix(r.Name),
+		Primary:      primary,
+		Replicas:     intFromMap(spec, "replicas"),
+		Spec:         spec,
+	}
+	if !primary {
+		w.ValuesKey = valuesKey(w.Suffix)
+	}
+
+	// Selector and pod labels are replaced by the chart's selector labels,
+	// and the ServiceAccount by the chart's own.
+	r.useMetadata()
+	r.Used.use("$.spec.replicas", "$.spec.selector", "$.spec.template.metadata.labels",
+		"$.spec.template.spec.serviceAccountName", "$.spec.template.spec.serviceAccount")
+	r.Used.useUnmodelled("$.spec", spec, modelledDeploymentFields)
 
 	tmpl, _ := spec["template"].(map[string]interface{})
 	podSpec, _ := tmpl["spec"].(map[string]interface{})
+	w.PodSpec = podSpec
+	r.Used.useUnmodelled("$.spec.template.spec", podSpec, modelledPodFields)
 	containers, _ := podSpec["containers"].([]interface{})
 	if len(containers) == 0 {
-		return fmt.Errorf("no containers found")
+		return Workload{}, fmt.Errorf("no containers found")
+	}
+
+	// The operator container is the one named after its Deployment, or the
+	// first one if none is.
+	operator := -1
+	if primary {
+		operator = 0
+		for i, c := range containers {
+			if name, _ := c.(map[string]interface{})["name"].(string); name == r.Name {
+				operator = i
+				break
+			}
+		}
+	}
+
+	for i, c := range containers {
+		container, err := u.parseContainer(c, i == operator, r.Used, indexPath("$.spec.template.spec.containers", i))
+		if err != nil {
+			return Workload{}, fmt.Errorf("containers[%d]: %w", i, err)
+		}
+		w.Containers = append(w.Containers, container)
+	}
+
+	initContainers, _ := podSpec["initContainers"].([]interface{})
+	for i, c := range initContainers {
+		container, err := u.parseContainer(c, false, r.Used, indexPath("$.spec.template.spec.initContainers", i))
+		if err != nil {
+			return Workload{}, fmt.Errorf("initContainers[%d]: %w", i, err)
+		}
+		w.InitContainers = append(w.InitContainers, container)
+	}
+
+	if primary {
+		u.OperatorImage = w.Containers[operator].Image
+		u.AppVersion = u.OperatorImage.Tag
+	}
+
+	for i := range w.Containers {
+		if i != operator {
+			w.Containers[i].ValuesKey = containerValuesKey(w, w.Containers[i].Name)
+		}
+	}
+	for i := range w.InitContainers {
+		w.InitContainers[i].ValuesKey = containerValuesKey(w, w.InitContainers[i].Name)
+	}
+
+	return w, nil
+}
+
+func (u *Upstream) parseContainer(v interface{}, primary bool, used fieldTracker, path string) (Container, error) {
+	container, ok := v.(map[string]interface{})
+	if !ok {
+		return Container{}, fmt.Errorf("not a mapping")
 	}
+	used.use(path+".name", path+".image")
+	used.useUnmodelled(path, container, modelledContainerFields)
 
-	container, _ := containers[0].(map[string]interface{})
+	c := Container{Spec: container, Primary: primary}
+	c.Name, _ = container["name"].(string)
+	if c.Name == "" {
+		return Container{}, fmt.Errorf("container has no name")
+	}
 
 	// Image
 	image, _ := container["image"].(string)
-	u.OperatorImage, u.AppVersion = splitImage(image)
-
-	// Container metadata
-	u.Deployment.ContainerName, _ = container["name"].(string)
+	if image == "" {
+		return Container{}, fmt.Errorf("container %q has no image", c.Name)
+	}
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return Container{}, fmt.Errorf("container %q: %w", c.Name, err)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		return Container{}, fmt.Errorf("container %q: image %q has no tag or digest", c.Name, image)
+	}
+	c.Image = ref
 
 	// Ports
 	ports, _ := container["ports"].([]interface{})
-	if len(ports) > 0 {
-		port, _ := ports[0].(map[string]interface{})
-		u.Deployment.ContainerPort = intFromMap(port, "containerPort")
+	for i, p := range ports {
+		port, _ := p.(map[string]interface{})
+		if err := checkKeys(port, "name", "containerPort", "protocol", "hostPort", "hostIP"); err != nil {
+			return Container{}, fmt.Errorf("container %q: ports[%d]: %w", c.Name, i, err)
+		}
+		cp := ContainerPort{
+			Name:          stringFromMap(port, "name"),
+			ContainerPort: intFromMap(port, "containerPort"),
+			Protocol:      stringFromMap(port, "protocol"),
+			HostPort:      intFromMap(port, "hostPort"),
+			HostIP:        stringFromMap(port, "hostIP"),
+		}
+		if cp.ContainerPort == 0 {
+			return Container{}, fmt.Errorf("container %q: ports[%d]: no containerPort", c.Name, i)
+		}
+		if primary && i == 0 && cp.Name == "" {
+			cp.Name = "http" // probes and the Service target the main port by name
+		}
+		c.Ports = append(c.Ports, cp)
 	}
+	used.use(path + ".ports")
 
 	// Resources
 	if resources, ok := container["resources"].(map[string]interface{}); ok {
-		u.Deployment.Resources = parseResources(resources)
+		c.Resources = parseResources(resources)
+		for _, list := range []string{"requests", "limits"} {
+			used.use(path+".resources."+list+".cpu", path+".resources."+list+".memory")
+		}
 	}
 
 	// Probes
-	u.Deployment.Probes.Liveness = parseProbe(container, "livenessProbe")
-	u.Deployment.Probes.Readiness = parseProbe(container, "readinessProbe")
-	u.Deployment.Probes.Startup = parseProbe(container, "startupProbe")
+	probes := []struct {
+		key   string
+		probe **ProbeSpec
+	}{
+		{"livenessProbe", &c.Probes.Liveness},
+		{"readinessProbe", &c.Probes.Readiness},
+		{"startupProbe", &c.Probes.Startup},
+	}
+	for _, p := range probes {
+		probe, ok := container[p.key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		spec, err := parseProbe(probe, c)
+		if err != nil {
+			return Container{}, fmt.Errorf("container %q: %s: %w", c.Name, p.key, err)
+		}
+		*p.probe = spec
+		used.use(path + "." + p.key)
+	}
 
 	// Env vars
 	envList, _ := container["env"].([]interface{})
-	for _, e := range envList {
-		env, _ := e.(map[string]interface{})
-		name, _ := env["name"].(string)
-
-		switch name {
-		case "KUBERNETES_NAMESPACE":
-			continue // handled by downward API in template
-		case "RELATED_IMAGE_KEYCLOAK":
-			value, _ := env["value"].(string)
-			u.KeycloakImage, _ = splitImage(value)
+	for i, e := range envList {
+		env, err := u.parseEnvVar(e)
+		if err != nil {
+			return Container{}, fmt.Errorf("container %q: env[%d]: %w", c.Name, i, err)
+		}
+		c.Env = append(c.Env, env)
+	}
+	used.use(path + ".env")
+
+	envFromList, _ := container["envFrom"].([]interface{})
+	for i, e := range envFromList {
+		envFrom, err := parseEnvFrom(e)
+		if err != nil {
+			return Container{}, fmt.Errorf("container %q: envFrom[%d]: %w", c.Name, i, err)
+		}
+		c.EnvFrom = append(c.EnvFrom, envFrom)
+	}
+	used.use(path + ".envFrom")
+
+	return c, nil
+}
+
+// containerValuesKey names the containers.<key> values entry of a container
+// that is not the operator container.
+func containerValuesKey(w Workload, name string) string {
+	if w.Primary {
+		return valuesKey(name)
+	}
+	return valuesKey(w.Suffix + "-" + name)
+}
+
+// valuesKey turns a Kubernetes name into a lowerCamelCase values key.
+func valuesKey(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	for i, p := range parts {
+		if i == 0 {
+			parts[i] = strings.ToLower(p[:1]) + p[1:]
+		} else {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+const relatedImagePrefix = "RELATED_IMAGE_"
+
+// addRelatedImage records the image of a RELATED_IMAGE_<NAME> env var and
+// returns the values key it is rendered from. The same variable may appear in
+// several containers, but only with the same image.
+func (u *Upstream) addRelatedImage(name, value string) (string, error) {
+	ref, err := ParseImageRef(value)
+	if err != nil {
+		return "", err
+	}
+	if name == relatedImagePrefix+"KEYCLOAK" {
+		if u.KeycloakImage.Repository != "" && u.KeycloakImage != ref {
+			return "", fmt.Errorf("image %s differs from %s set elsewhere", ref, u.KeycloakImage)
+		}
+		u.KeycloakImage = ref
+		return "keycloakImage", nil
+	}
+
+	key := valuesKey(strings.ToLower(strings.TrimPrefix(name, relatedImagePrefix)))
+	if key == "" {
+		return "", fmt.Errorf("cannot derive a values key")
+	}
+	for _, r := range u.RelatedImages {
+		if r.ValuesKey != key {
+			continue
+		}
+		if r.EnvName != name {
+			return "", fmt.Errorf("%s and %s both map to relatedImages.%s", r.EnvName, name, key)
+		}
+		if r.Image != ref {
+			return "", fmt.Errorf("image %s differs from %s set elsewhere", ref, r.Image)
+		}
+		return "relatedImages." + key, nil
+	}
+	u.RelatedImages = append(u.RelatedImages, RelatedImage{EnvName: name, ValuesKey: key, Image: ref})
+	return "relatedImages." + key, nil
+}
+
+func (u *Upstream) parseEnvVar(v interface{}) (EnvVar, error) {
+	env, ok := v.(map[string]interface{})
+	if !ok {
+		return EnvVar{}, fmt.Errorf("not a mapping")
+	}
+	e := EnvVar{Name: stringFromMap(env, "name")}
+	if e.Name == "" {
+		return EnvVar{}, fmt.Errorf("env entry has no name")
+	}
+	if err := checkKeys(env, "name", "value", "valueFrom"); err != nil {
+		return EnvVar{}, fmt.Errorf("%s: %w", e.Name, err)
+	}
+
+	valueFrom, hasValueFrom := env["valueFrom"].(map[string]interface{})
+	if !hasValueFrom {
+		e.Value = scalarString(env["value"])
+		if strings.HasPrefix(e.Name, relatedImagePrefix) {
+			values, err := u.addRelatedImage(e.Name, e.Value)
+			if err != nil {
+				return EnvVar{}, fmt.Errorf("%s: %w", e.Name, err)
+			}
+			e.ImageValues = values
+		}
+		return e, nil
+	}
+
+	source, err := parseEnvVarSource(valueFrom)
+	if err != nil {
+		return EnvVar{}, fmt.Errorf("%s: %w", e.Name, err)
+	}
+	e.ValueFrom = source
+	return e, nil
+}
+
+func parseEnvVarSource(m map[string]interface{}) (*EnvVarSource, error) {
+	if len(m) != 1 {
+		return nil, fmt.Errorf("valueFrom must have exactly one source")
+	}
+
+	s := &EnvVarSource{}
+	for kind, v := range m {
+		ref, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("valueFrom.%s: not a mapping", kind)
+		}
+		var err error
+		switch kind {
+		case "fieldRef":
+			s.FieldRef = &ObjectFieldSelector{
+				APIVersion: stringFromMap(ref, "apiVersion"),
+				FieldPath:  stringFromMap(ref, "fieldPath"),
+			}
+			err = checkKeys(ref, "apiVersion", "fieldPath")
+		case "resourceFieldRef":
+			s.ResourceFieldRef = &ResourceFieldSelector{
+				ContainerName: stringFromMap(ref, "containerName"),
+				Resource:      stringFromMap(ref, "resource"),
+				Divisor:       scalarString(ref["divisor"]),
+			}
+			err = checkKeys(ref, "containerName", "resource", "divisor")
+		case "configMapKeyRef":
+			s.ConfigMapKeyRef = parseKeySelector(ref)
+			err = checkKeys(ref, "name", "key", "optional")
+		case "secretKeyRef":
+			s.SecretKeyRef = parseKeySelector(ref)
+			err = checkKeys(ref, "name", "key", "optional")
 		default:
-			value, _ := env["value"].(string)
-			u.Deployment.ExtraEnv = append(u.Deployment.ExtraEnv, StaticEnvVar{
-				Name:  name,
-				Value: value,
-			})
+			return nil, fmt.Errorf("unsupported valueFrom source %q", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("valueFrom.%s: %w", kind, err)
 		}
 	}
+	return s, nil
+}
+
+func parseKeySelector(m map[string]interface{}) *KeySelector {
+	return &KeySelector{
+		Name:     stringFromMap(m, "name"),
+		Key:      stringFromMap(m, "key"),
+		Optional: optionalBool(m),
+	}
+}
+
+func parseEnvFrom(v interface{}) (EnvFromSource, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return EnvFromSource{}, fmt.Errorf("not a mapping")
+	}
+	if err := checkKeys(m, "prefix", "configMapRef", "secretRef"); err != nil {
+		return EnvFromSource{}, err
+	}
 
+	e := EnvFromSource{Prefix: stringFromMap(m, "prefix")}
+	if ref, ok := m["configMapRef"].(map[string]interface{}); ok {
+		e.ConfigMapRef = &SourceRef{Name: stringFromMap(ref, "name"), Optional: optionalBool(ref)}
+	}
+	if ref, ok := m["secretRef"].(map[string]interface{}); ok {
+		e.SecretRef = &SourceRef{Name: stringFromMap(ref, "name"), Optional: optionalBool(ref)}
+	}
+	if (e.ConfigMapRef == nil) == (e.SecretRef == nil) {
+		return EnvFromSource{}, fmt.Errorf("envFrom must have exactly one of configMapRef or secretRef")
+	}
+	return e, nil
+}
+
+// checkKeys fails on any key of m outside allowed, so that fields the
+// templates cannot render are never silently lost.
+func checkKeys(m map[string]interface{}, allowed ...string) error {
+	for k := range m {
+		found := false
+		for _, a := range allowed {
+			if k == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unsupported field %q", k)
+		}
+	}
 	return nil
 }
 
-func (u *Upstream) parseService(r rawResource) {
+func optionalBool(m map[string]interface{}) *bool {
+	b, ok := m["optional"].(bool)
+	if !ok {
+		return nil
+	}
+	return &b
+}
+
+// scalarString returns a YAML scalar as the string Kubernetes would see.
+func scalarString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func (u *Upstream) parseService(r rawResource) error {
 	spec, _ := r.Raw["spec"].(map[string]interface{})
 	u.Service.Type, _ = spec["type"].(string)
 
+	// The selector is replaced by the chart's selector labels.
+	r.useMetadata()
+	r.Used.use("$.spec.type", "$.spec.selector")
+
 	ports, _ := spec["ports"].([]interface{})
-	if len(ports) > 0 {
-		port, _ := ports[0].(map[string]interface{})
-		u.Service.Port = intFromMap(port, "port")
+	for i, p := range ports {
+		port, _ := p.(map[string]interface{})
+		if err := checkKeys(port, "name", "port", "targetPort", "protocol", "nodePort", "appProtocol"); err != nil {
+			return fmt.Errorf("ports[%d]: %w", i, err)
+		}
+		sp := ServicePort{
+			Name:        stringFromMap(port, "name"),
+			Port:        intFromMap(port, "port"),
+			TargetPort:  scalarString(port["targetPort"]),
+			Protocol:    stringFromMap(port, "protocol"),
+			NodePort:    intFromMap(port, "nodePort"),
+			AppProtocol: stringFromMap(port, "appProtocol"),
+		}
+		if sp.Port == 0 {
+			return fmt.Errorf("ports[%d]: no port", i)
+		}
+		u.Service.Ports = append(u.Service.Ports, sp)
 	}
+	r.Used.use("$.spec.ports")
+	return nil
 }
 
-func splitImage(ref string) (repo, tag string) {
-	if i := strings.LastIndex(ref, ":"); i != -1 {
-		return ref[:i], ref[i+1:]
+// mapServiceTargetPorts turns numeric Service target ports that match a named
+// operator container port into that name.
+func (u *Upstream) mapServiceTargetPorts() {
+	operator := u.OperatorContainer()
+	for i, p := range u.Service.Ports {
+		if n, err := strconv.Atoi(p.TargetPort); err == nil {
+			if name := operator.PortName(n); name != "" {
+				u.Service.Ports[i].TargetPort = name
+			}
+		}
 	}
-	return ref, ""
 }
 
 func intFromMap(m map[string]interface{}, key string) int {
@@ -259,23 +808,140 @@ func parseResources(r map[string]interface{}) ResourceRequirements {
 	return rr
 }
 
-func parseProbe(container map[string]interface{}, key string) ProbeSpec {
-	probe, ok := container[key].(map[string]interface{})
-	if !ok {
-		return ProbeSpec{}
+func parseProbe(probe map[string]interface{}, c Container) (*ProbeSpec, error) {
+	err := checkKeys(probe, "httpGet", "tcpSocket", "exec", "grpc", "failureThreshold", "initialDelaySeconds",
+		"periodSeconds", "successThreshold", "timeoutSeconds", "terminationGracePeriodSeconds")
+	if err != nil {
+		return nil, err
 	}
 
-	httpGet, _ := probe["httpGet"].(map[string]interface{})
-	path, _ := httpGet["path"].(string)
-
-	return ProbeSpec{
-		Path:                path,
+	p := &ProbeSpec{
 		FailureThreshold:    intFromMap(probe, "failureThreshold"),
 		InitialDelaySeconds: intFromMap(probe, "initialDelaySeconds"),
 		PeriodSeconds:       intFromMap(probe, "periodSeconds"),
 		SuccessThreshold:    intFromMap(probe, "successThreshold"),
 		TimeoutSeconds:      intFromMap(probe, "timeoutSeconds"),
 	}
+	if _, ok := probe["terminationGracePeriodSeconds"]; ok {
+		n := intFromMap(probe, "terminationGracePeriodSeconds")
+		p.TerminationGracePeriodSeconds = &n
+	}
+
+	handlers := 0
+	if m, ok := probe["httpGet"].(map[string]interface{}); ok {
+		handlers++
+		if err := checkKeys(m, "path", "port", "host", "scheme", "httpHeaders"); err != nil {
+			return nil, fmt.Errorf("httpGet: %w", err)
+		}
+		p.HTTPGet = &HTTPGetAction{
+			Path:   stringFromMap(m, "path"),
+			Port:   portRef(m["port"], c),
+			Host:   stringFromMap(m, "host"),
+			Scheme: stringFromMap(m, "scheme"),
+		}
+		headers, _ := m["httpHeaders"].([]interface{})
+		for _, h := range headers {
+			header, _ := h.(map[string]interface{})
+			p.HTTPGet.HTTPHeaders = append(p.HTTPGet.HTTPHeaders, HTTPHeader{
+				Name:  stringFromMap(header, "name"),
+				Value: scalarString(header["value"]),
+			})
+		}
+	}
+	if m, ok := probe["tcpSocket"].(map[string]interface{}); ok {
+		handlers++
+		if err := checkKeys(m, "port", "host"); err != nil {
+			return nil, fmt.Errorf("tcpSocket: %w", err)
+		}
+		p.TCPSocket = &TCPSocketAction{
+			Port: portRef(m["port"], c),
+			Host: stringFromMap(m, "host"),
+		}
+	}
+	if m, ok := probe["exec"].(map[string]interface{}); ok {
+		handlers++
+		if err := checkKeys(m, "command"); err != nil {
+			return nil, fmt.Errorf("exec: %w", err)
+		}
+		p.Exec = &ExecAction{}
+		command, _ := m["command"].([]interface{})
+		for _, arg := range command {
+			p.Exec.Command = append(p.Exec.Command, scalarString(arg))
+		}
+	}
+	if m, ok := probe["grpc"].(map[string]interface{}); ok {
+		handlers++
+		if err := checkKeys(m, "port", "service"); err != nil {
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+		p.GRPC = &GRPCAction{Port: intFromMap(m, "port")}
+		if service, ok := m["service"].(string); ok {
+			p.GRPC.Service = &service
+		}
+	}
+	if handlers != 1 {
+		return nil, fmt.Errorf("probe must have exactly one handler, found %d", handlers)
+	}
+
+	return p, nil
+}
+
+// portRef returns a reference to a port of c as rendered. A number matching a
+// named container port becomes that name, so the reference follows the port
+// if its number changes.
+func portRef(v interface{}, c Container) string {
+	if n, ok := v.(int); ok {
+		if name := c.PortName(n); name != "" {
+			return name
+		}
+	}
+	return scalarString(v)
+}
+
+// Fields that the chart templates render from values or parsed data. Anything
+// else in the upstream spec is rendered verbatim by passthroughYAML.
+var (
+	modelledDeploymentFields = map[string]bool{
+		"replicas": true,
+		"selector": true,
+		"template": true,
+	}
+	modelledPodFields = map[string]bool{
+		"containers":         true,
+		"initContainers":     true,
+		"serviceAccount":     true,
+		"serviceAccountName": true,
+		"imagePullSecrets":   true,
+		"nodeSelector":       true,
+		"affinity":           true,
+		"tolerations":        true,
+	}
+	modelledContainerFields = map[string]bool{
+		"name":            true,
+		"image":           true,
+		"imagePullPolicy": true,
+		"env":             true,
+		"envFrom":         true,
+		"ports":           true,
+		"resources":       true,
+		"livenessProbe":   true,
+		"readinessProbe":  true,
+		"startupProbe":    true,
+	}
+)
+
+// passthroughYAML marshals the entries of m that are not in modelled.
+func passthroughYAML(m map[string]interface{}, modelled map[string]bool) (string, error) {
+	rest := make(map[string]interface{})
+	for k, v := range m {
+		if !modelled[k] {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		return "", nil
+	}
+	return marshalYAML(rest)
 }
 
 func marshalYAML(v interface{}) (string, error) {
@@ -0,0 +1,769 @@
+package chart
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// operatorManifest is a minimal upstream manifest: the operator's
+// ServiceAccount, Service and Deployment.
+const operatorManifest = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: keycloak-operator
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: keycloak-operator
+spec:
+  type: ClusterIP
+  selector:
+    app: keycloak-operator
+  ports:
+    - name: http
+      port: 80
+      targetPort: 8080
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: keycloak-operator
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: keycloak-operator
+  template:
+    metadata:
+      labels:
+        app: keycloak-operator
+    spec:
+      containers:
+        - name: keycloak-operator
+          image: quay.io/keycloak/keycloak-operator:26.5.3
+          ports:
+            - containerPort: 8080
+          env:
+            - name: RELATED_IMAGE_KEYCLOAK
+              value: quay.io/keycloak/keycloak:26.5.3
+`
+
+// testCRD is a minimal CRD to generate charts with.
+const testCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  scope: Namespaced
+  names:
+    kind: Widget
+    singular: widget
+    plural: widgets
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required: [size]
+              properties:
+                size:
+                  type: integer
+                  minimum: 1
+`
+
+// parseManifest parses the documents as one upstream manifest.
+func parseManifest(t *testing.T, docs ...string) *Upstream {
+	t.Helper()
+	u, err := Parse([]byte(strings.Join(docs, "\n---\n")), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// generateChart generates a chart from u with testCRD and returns its files
+// by path relative to the chart.
+func generateChart(t *testing.T, u *Upstream, opts Options) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	opts.CRDs = append(opts.CRDs, Source{Name: "widgets.example.com-v1.yml", Data: []byte(testCRD)})
+	if err := Generate(u, dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// containsAll reports the strings in want that s lacks.
+func containsAll(t *testing.T, name, s string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(s, w) {
+			t.Errorf("%s lacks %q:\n%s", name, w, s)
+		}
+	}
+}
+
+const webhookDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: keycloak-operator-webhook
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: webhook
+  template:
+    metadata:
+      labels:
+        app: webhook
+    spec:
+      initContainers:
+        - name: migrate
+          image: quay.io/keycloak/webhook:1.0
+      containers:
+        - name: webhook
+          image: quay.io/keycloak/webhook:1.0
+`
+
+func TestParseWorkloads(t *testing.T) {
+	// The operator Deployment gets a log shipper sidecar ahead of the
+	// operator container.
+	withSidecar := strings.Replace(operatorManifest, `      containers:
+        - name: keycloak-operator`, `      containers:
+        - name: log-shipper
+          image: fluent/fluent-bit:3.0
+        - name: keycloak-operator`, 1)
+	u := parseManifest(t, withSidecar, webhookDeployment)
+
+	if len(u.Workloads) != 2 {
+		t.Fatalf("got %d workloads, want 2", len(u.Workloads))
+	}
+	op := u.OperatorWorkload()
+	if op.OriginalName != "keycloak-operator" || len(op.Containers) != 2 {
+		t.Errorf("operator workload = %s with %d containers", op.OriginalName, len(op.Containers))
+	}
+	if c := u.OperatorContainer(); c.Name != "keycloak-operator" || u.AppVersion != "26.5.3" {
+		t.Errorf("operator container = %s, appVersion %s", c.Name, u.AppVersion)
+	}
+
+	var keys []string
+	for _, w := range u.ExtraWorkloads() {
+		keys = append(keys, "workloads."+w.ValuesKey)
+	}
+	for _, c := range u.ExtraContainers() {
+		keys = append(keys, "containers."+c.ValuesKey)
+	}
+	want := "workloads.webhook containers.logShipper containers.webhookMigrate containers.webhookWebhook"
+	if got := strings.Join(keys, " "); got != want {
+		t.Errorf("values keys = %s, want %s", got, want)
+	}
+
+	files := generateChart(t, u, Options{})
+	containsAll(t, "values.yaml", files["values.yaml"],
+		"workloads:\n  webhook:\n    replicas: 2\n    nodeSelector: {}\n    tolerations: []\n    affinity: {}\n    podAnnotations: {}\n    podLabels: {}\n",
+		"  logShipper:\n    image:\n      registry: \"\"\n      repository: fluent/fluent-bit\n      tag: \"3.0\"\n",
+		"      pullPolicy: IfNotPresent\n",
+	)
+	containsAll(t, "deployment.yaml", files["templates/deployment.yaml"],
+		`name: {{ include "keycloak-operator.fullname" . }}-webhook`,
+		"replicas: {{ .Values.workloads.webhook.replicas }}",
+		"{{- with .Values.workloads.webhook.nodeSelector }}",
+		"{{- with .Values.workloads.webhook.podAnnotations }}",
+		"{{- with .Values.nodeSelector }}",
+		"imagePullPolicy: {{ .Values.containers.logShipper.image.pullPolicy }}",
+		"imagePullPolicy: {{ .Values.image.pullPolicy }}",
+		"initContainers:\n        - name: migrate\n",
+	)
+}
+
+func TestParseWorkloadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"StatefulSet",
+			"apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\n",
+			"only Deployments",
+		},
+		{
+			"no containers",
+			"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: empty\nspec:\n  template:\n    spec: {}\n",
+			"no containers",
+		},
+		{
+			"untagged image",
+			strings.Replace(webhookDeployment, "webhook:1.0\n", "webhook\n", 1),
+			"has no tag or digest",
+		},
+		{
+			"same values key",
+			webhookDeployment + "---\n" + strings.Replace(webhookDeployment, "keycloak-operator-webhook", "keycloak-operator-Webhook", 1),
+			"both map to workloads.webhook",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(operatorManifest+"---\n"+tt.doc), "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassthroughFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string // text in operatorManifest to insert after
+		insert  string
+		want    string // text expected in templates/deployment.yaml
+		dropped bool
+	}{
+		{
+			"deployment strategy",
+			"  replicas: 1\n",
+			"  strategy:\n    type: Recreate\n",
+			"  strategy:\n    type: Recreate\n  template:",
+			false,
+		},
+		{
+			"pod volumes",
+			"        app: keycloak-operator\n    spec:\n",
+			"      volumes:\n        - name: tmp\n          emptyDir: {}\n",
+			"      volumes:\n        - emptyDir: {}\n          name: tmp\n",
+			false,
+		},
+		{
+			"pod security context",
+			"        app: keycloak-operator\n    spec:\n",
+			"      securityContext:\n        runAsNonRoot: true\n",
+			"      serviceAccountName: {{ include \"keycloak-operator.serviceAccountName\" . }}\n      securityContext:\n        runAsNonRoot: true\n",
+			false,
+		},
+		{
+			"container args",
+			"          image: quay.io/keycloak/keycloak-operator:26.5.3\n",
+			"          args:\n            - --verbose\n",
+			"          args:\n            - --verbose\n",
+			false,
+		},
+		{
+			"container security context",
+			"          image: quay.io/keycloak/keycloak-operator:26.5.3\n",
+			"          securityContext:\n            readOnlyRootFilesystem: true\n",
+			"          securityContext:\n            readOnlyRootFilesystem: true\n",
+			false,
+		},
+		{
+			"modelled pull policy",
+			"          image: quay.io/keycloak/keycloak-operator:26.5.3\n",
+			"          imagePullPolicy: Always\n",
+			"imagePullPolicy: {{ .Values.image.pullPolicy }}",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := strings.Replace(operatorManifest, tt.old, tt.old+tt.insert, 1)
+			u := parseManifest(t, manifest)
+			if got := len(u.Drops) > 0; got != tt.dropped {
+				t.Errorf("dropped = %v, want %v: %v", got, tt.dropped, u.Drops)
+			}
+			files := generateChart(t, u, Options{})
+			containsAll(t, "deployment.yaml", files["templates/deployment.yaml"], tt.want)
+		})
+	}
+}
+
+func TestPassthroughResources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upstream.ignore")
+	if err := os.WriteFile(path, []byte("Tenant/* $\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ignored, err := LoadIgnore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		file string // rendered template, empty if not passed through
+		want string // first line of the rendered template
+	}{
+		{
+			"known namespaced kind",
+			"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: keycloak-operator-config\ndata:\n  a: b\n",
+			"templates/upstream/configmap-config.yaml",
+			"{{- if .Values.upstream.configMapConfig.enabled }}",
+		},
+		{
+			"unknown kind defaults to namespaced",
+			"apiVersion: monitoring.example.com/v1\nkind: Probe\nmetadata:\n  name: keycloak-operator\nspec:\n  interval: 30s\n",
+			"templates/upstream/probe.yaml",
+			"{{- if .Values.upstream.probe.enabled }}",
+		},
+		{
+			"cluster-scoped kind",
+			"apiVersion: scheduling.k8s.io/v1\nkind: PriorityClass\nmetadata:\n  name: keycloak-operator-critical\nvalue: 1000\n",
+			"templates/upstream/priorityclass-critical.yaml",
+			"{{- if and .Values.clusterScoped.enabled .Values.upstream.priorityClassCritical.enabled }}",
+		},
+		{
+			"excluded by the ignore list",
+			"apiVersion: tenancy.example.com/v1\nkind: Tenant\nmetadata:\n  name: keycloak\nspec: {}\n",
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := Parse([]byte(operatorManifest+"---\n"+tt.doc), "", ignored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := generateChart(t, u, Options{})
+			if tt.file == "" {
+				if len(u.Resources) != 0 {
+					t.Errorf("resources = %+v, want none", u.Resources)
+				}
+				if drops := ignored.Unacknowledged(u.Drops); len(drops) != 0 || len(u.Drops) == 0 {
+					t.Errorf("drops = %v, want the resource acknowledged as dropped", u.Drops)
+				}
+				return
+			}
+			got, ok := files[tt.file]
+			if !ok {
+				t.Fatalf("%s not generated", tt.file)
+			}
+			if first := strings.SplitN(got, "\n", 2)[0]; first != tt.want {
+				t.Errorf("%s starts with %q, want %q", tt.file, first, tt.want)
+			}
+			if len(u.Drops) != 0 {
+				t.Errorf("drops = %v, want none", u.Drops)
+			}
+		})
+	}
+}
+
+// withOperatorEnv inserts YAML after the operator container's env list.
+func withOperatorEnv(env string) string {
+	anchor := "              value: quay.io/keycloak/keycloak:26.5.3\n"
+	return strings.Replace(operatorManifest, anchor, anchor+env, 1)
+}
+
+func TestParseEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string // inserted into the operator container after env
+		want string // expected in templates/deployment.yaml
+	}{
+		{
+			"plain value",
+			"            - name: QUARKUS_LOG_LEVEL\n              value: \"DEBUG\"\n",
+			"            - name: QUARKUS_LOG_LEVEL\n              value: DEBUG\n",
+		},
+		{
+			"fieldRef",
+			"            - name: POD_NAMESPACE\n              valueFrom:\n                fieldRef:\n                  apiVersion: v1\n                  fieldPath: metadata.namespace\n",
+			"            - name: POD_NAMESPACE\n              valueFrom:\n                fieldRef:\n                  apiVersion: v1\n                  fieldPath: metadata.namespace\n",
+		},
+		{
+			"resourceFieldRef",
+			"            - name: MEMORY_LIMIT\n              valueFrom:\n                resourceFieldRef:\n                  resource: limits.memory\n                  divisor: 1Mi\n",
+			"                resourceFieldRef:\n                  resource: limits.memory\n                  divisor: 1Mi\n",
+		},
+		{
+			"configMapKeyRef",
+			"            - name: THEME\n              valueFrom:\n                configMapKeyRef:\n                  name: branding\n                  key: theme\n                  optional: true\n",
+			"                configMapKeyRef:\n                  name: branding\n                  key: theme\n                  optional: true\n",
+		},
+		{
+			"secretKeyRef",
+			"            - name: DB_PASSWORD\n              valueFrom:\n                secretKeyRef:\n                  name: db\n                  key: password\n",
+			"                secretKeyRef:\n                  name: db\n                  key: password\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := parseManifest(t, withOperatorEnv(tt.env))
+			files := generateChart(t, u, Options{})
+			containsAll(t, "deployment.yaml", files["templates/deployment.yaml"], tt.want)
+		})
+	}
+}
+
+func TestParseEnvFrom(t *testing.T) {
+	envFrom := "          envFrom:\n" +
+		"            - configMapRef:\n                name: keycloak-operator-config\n" +
+		"            - secretRef:\n                name: credentials\n                optional: true\n              prefix: CRED_\n"
+	config := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: keycloak-operator-config\ndata:\n  a: b\n"
+
+	u := parseManifest(t, withOperatorEnv(envFrom), config)
+	files := generateChart(t, u, Options{})
+	containsAll(t, "deployment.yaml", files["templates/deployment.yaml"],
+		// A ConfigMap the chart renders is referred to by its chart name.
+		"            - configMapRef:\n                name: {{ include \"keycloak-operator.fullname\" . }}-config\n",
+		"            - secretRef:\n                name: credentials\n                optional: true\n              prefix: CRED_\n",
+	)
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{
+			"unknown source",
+			"            - name: X\n              valueFrom:\n                fileKeyRef:\n                  path: x\n",
+			"fileKeyRef",
+		},
+		{
+			"two sources",
+			"            - name: X\n              valueFrom:\n                fieldRef:\n                  fieldPath: metadata.name\n                secretKeyRef:\n                  name: a\n                  key: b\n",
+			"exactly one",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(withOperatorEnv(tt.env)), "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// withOperatorProbe adds a liveness probe to the operator container.
+func withOperatorProbe(probe string) string {
+	anchor := "            - containerPort: 8080\n"
+	return strings.Replace(operatorManifest, anchor, anchor+"          livenessProbe:\n"+probe, 1)
+}
+
+func TestParseProbes(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string // the operator's livenessProbe, indented for the manifest
+		want  string // expected in templates/deployment.yaml
+	}{
+		{
+			"httpGet by port number",
+			"            httpGet:\n              path: /q/health/live\n              port: 8080\n              scheme: HTTPS\n",
+			"            httpGet:\n              path: /q/health/live\n              port: http\n              scheme: HTTPS\n",
+		},
+		{
+			"tcpSocket",
+			"            tcpSocket:\n              port: 8080\n",
+			"            tcpSocket:\n              port: http\n",
+		},
+		{
+			"tcpSocket on another port",
+			"            tcpSocket:\n              port: 9000\n",
+			"            tcpSocket:\n              port: 9000\n",
+		},
+		{
+			"exec",
+			"            exec:\n              command: [cat, /tmp/healthy]\n",
+			"            exec:\n              command:\n                - cat\n                - /tmp/healthy\n",
+		},
+		{
+			"grpc",
+			"            grpc:\n              port: 9090\n              service: health\n",
+			"            grpc:\n              port: 9090\n              service: health\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := parseManifest(t, withOperatorProbe(tt.probe+"            periodSeconds: 15\n"))
+			files := generateChart(t, u, Options{})
+			containsAll(t, "deployment.yaml", files["templates/deployment.yaml"],
+				"          {{- if .Values.probes.liveness.enabled }}\n          livenessProbe:\n"+tt.want)
+			// Timings are values, rendered after the handler.
+			containsAll(t, "values.yaml", files["values.yaml"],
+				"probes:\n  liveness:\n    enabled: true\n    periodSeconds: 15\n")
+		})
+	}
+}
+
+func TestParseProbeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string
+		want  string
+	}{
+		{"no handler", "            periodSeconds: 10\n", "exactly one handler, found 0"},
+		{
+			"two handlers",
+			"            exec:\n              command: [\"true\"]\n            tcpSocket:\n              port: 8080\n",
+			"exactly one handler, found 2",
+		},
+		{"unknown field", "            exec:\n              command: [\"true\"]\n            retries: 3\n", `"retries"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(withOperatorProbe(tt.probe)), "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperatorPortValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		targetPort string // the upstream Service's targetPort
+		want       string // its rendered form
+	}{
+		{"numbered operator port", "8080", "targetPort: http"},
+		{"named operator port", "http", "targetPort: http"},
+		{"other port", "9000", "targetPort: 9000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := withOperatorProbe("            httpGet:\n              path: /q/health\n              port: 8080\n")
+			u := parseManifest(t, strings.Replace(manifest, "targetPort: 8080", "targetPort: "+tt.targetPort, 1))
+
+			files := generateChart(t, u, Options{})
+			containsAll(t, "values.yaml", files["values.yaml"],
+				"# target it by that name, so they follow when it changes.\ncontainerPort: 8080\n",
+				"probes:\n  liveness:\n    enabled: true\n",
+			)
+			containsAll(t, "deployment.yaml", files["templates/deployment.yaml"],
+				"            - name: http\n              containerPort: {{ .Values.containerPort }}\n",
+				"              port: http\n",
+				"            {{- with omit .Values.probes.liveness \"enabled\" }}\n",
+			)
+			containsAll(t, "service.yaml", files["templates/service.yaml"],
+				"    - port: {{ .Values.service.port }}\n      "+tt.want+"\n")
+		})
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	// The operator Service gets a second port, and a second Service exposes
+	// the operator's metrics port.
+	manifest := strings.Replace(operatorManifest, "      targetPort: 8080\n",
+		"      targetPort: 8080\n    - name: metrics\n      port: 9000\n      targetPort: metrics\n      protocol: TCP\n", 1)
+	manifest = strings.Replace(manifest, "            - containerPort: 8080\n",
+		"            - containerPort: 8080\n            - name: metrics\n              containerPort: 9000\n", 1)
+	metrics := `apiVersion: v1
+kind: Service
+metadata:
+  name: keycloak-operator-metrics
+spec:
+  selector:
+    app: keycloak-operator
+  ports:
+    - name: metrics
+      port: 9000
+`
+	u := parseManifest(t, manifest, metrics)
+
+	if len(u.Service.Ports) != 2 {
+		t.Errorf("Service ports = %+v, want the operator Service's two", u.Service.Ports)
+	}
+	if len(u.Resources) != 1 || u.Resources[0].Kind != "Service" {
+		t.Fatalf("resources = %+v, want the metrics Service", u.Resources)
+	}
+
+	files := generateChart(t, u, Options{})
+	containsAll(t, "service.yaml", files["templates/service.yaml"],
+		"    - port: {{ .Values.service.port }}\n      targetPort: http\n",
+		"    - port: 9000\n      targetPort: metrics\n      protocol: TCP\n      name: metrics\n",
+	)
+	containsAll(t, "deployment.yaml", files["templates/deployment.yaml"],
+		"            - name: metrics\n              containerPort: 9000\n")
+	containsAll(t, "upstream/service-metrics.yaml", files["templates/upstream/service-metrics.yaml"],
+		`  name: {{ include "keycloak-operator.fullname" . }}-metrics`,
+		"  selector:\n    {{- include \"keycloak-operator.selectorLabels\" . | nindent 4 }}\n",
+	)
+}
+
+func TestParseRelatedImages(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string // inserted into the operator container after env
+		values string // expected in values.yaml
+		value  string // expected rendered env value
+	}{
+		{
+			"single word",
+			"            - name: RELATED_IMAGE_UPDATER\n              value: quay.io/keycloak/updater:1.2\n",
+			"relatedImages:\n  updater:\n    # RELATED_IMAGE_UPDATER\n    registry: quay.io\n    repository: keycloak/updater\n    tag: \"1.2\"\n",
+			`(dict "image" .Values.relatedImages.updater "context" .)`,
+		},
+		{
+			"underscored name",
+			"            - name: RELATED_IMAGE_DB_UPDATER\n              value: quay.io/keycloak/db-updater@sha256:" + strings.Repeat("a", 64) + "\n",
+			"relatedImages:\n  dbUpdater:\n    # RELATED_IMAGE_DB_UPDATER\n    registry: quay.io\n    repository: keycloak/db-updater\n    tag: \"\"\n    # Pulls by digest instead of tag when set\n    digest: sha256:" + strings.Repeat("a", 64) + "\n",
+			`(dict "image" .Values.relatedImages.dbUpdater "context" .)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := parseManifest(t, withOperatorEnv(tt.env))
+			if len(u.RelatedImages) != 1 {
+				t.Fatalf("related images = %+v, want one", u.RelatedImages)
+			}
+			if got := len(u.Images()); got != 3 {
+				t.Errorf("got %d images, want operator, Keycloak and the related one", got)
+			}
+			files := generateChart(t, u, Options{})
+			containsAll(t, "values.yaml", files["values.yaml"], tt.values)
+			containsAll(t, "deployment.yaml", files["templates/deployment.yaml"], tt.value)
+		})
+	}
+}
+
+func TestParseRelatedImageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{
+			"conflicting Keycloak image",
+			"            - name: RELATED_IMAGE_KEYCLOAK\n              value: quay.io/keycloak/keycloak:26.0.0\n",
+			"differs from",
+		},
+		{
+			"names with the same values key",
+			"            - name: RELATED_IMAGE_DB_UPDATER\n              value: a/b:1\n            - name: RELATED_IMAGE_DB__UPDATER\n              value: a/b:1\n",
+			"both map to relatedImages.dbUpdater",
+		},
+		{
+			"invalid reference",
+			"            - name: RELATED_IMAGE_UPDATER\n              value: quay.io/keycloak/updater@sha256:abc\n",
+			"invalid digest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(withOperatorEnv(tt.env)), "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// imageRe matches the image of a rendered container.
+var imageRe = regexp.MustCompile(`(?m)^ *image: .*$`)
+
+func TestImagesFollowRegistryOverride(t *testing.T) {
+	withSidecar := strings.Replace(operatorManifest, `      containers:
+        - name: keycloak-operator`, `      containers:
+        - name: log-shipper
+          image: fluent/fluent-bit:3.0
+        - name: keycloak-operator`, 1)
+	manifest := strings.Replace(withSidecar, "              value: quay.io/keycloak/keycloak:26.5.3\n",
+		"              value: quay.io/keycloak/keycloak:26.5.3\n            - name: RELATED_IMAGE_UPDATER\n              value: quay.io/keycloak/updater:1.2\n", 1)
+	u := parseManifest(t, manifest, webhookDeployment)
+	files := generateChart(t, u, Options{})
+	deployment := files["templates/deployment.yaml"]
+
+	// Every image is rendered by the helper that applies
+	// global.imageRegistry, and none is left literal.
+	want := []string{
+		`(dict "image" .Values.image "context" .)`,
+		`(dict "image" .Values.keycloakImage "context" .)`,
+		`(dict "image" .Values.relatedImages.updater "context" .)`,
+		`(dict "image" .Values.containers.logShipper.image "context" .)`,
+		`(dict "image" .Values.containers.webhookMigrate.image "context" .)`,
+		`(dict "image" .Values.containers.webhookWebhook.image "context" .)`,
+	}
+	containsAll(t, "deployment.yaml", deployment, want...)
+	images := imageRe.FindAllString(deployment, -1)
+	if len(images) != 4 {
+		t.Errorf("got %d container images, want 4", len(images))
+	}
+	for _, image := range images {
+		if !strings.Contains(image, `include "keycloak-operator.image"`) {
+			t.Errorf("literal image in deployment.yaml: %s", image)
+		}
+	}
+
+	// Both Deployments get the pull secrets, including the one created from
+	// imagePullCredentials.
+	if n := strings.Count(deployment, `{{- with include "keycloak-operator.imagePullSecrets" . }}`); n != 2 {
+		t.Errorf("imagePullSecrets rendered in %d pod specs, want 2", n)
+	}
+	containsAll(t, "imagepullsecret.yaml", files["templates/imagepullsecret.yaml"],
+		"{{- if .Values.imagePullCredentials.create -}}",
+		"type: kubernetes.io/dockerconfigjson",
+	)
+	containsAll(t, "values.yaml", files["values.yaml"],
+		"global:\n",
+		"  imageRegistry: \"\"\n",
+		"imagePullCredentials:\n  create: false\n  registry: \"\"\n",
+	)
+}
+
+func TestGenerateCRDDirectories(t *testing.T) {
+	u := parseManifest(t, operatorManifest)
+	crds := []Source{{Name: "widgets.example.com-v1.yml", Data: []byte(testCRD)}}
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "crds", "removed.yml")
+	if err := os.MkdirAll(filepath.Dir(stale), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stale, []byte("kind: CustomResourceDefinition\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Generate(u, dir, Options{ChartVersion: "1.0.0", CRDs: crds}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale CRD in crds/ was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "crds", "widgets.example.com-v1.yml")); err != nil {
+		t.Errorf("CRD not written: %v", err)
+	}
+
+	// Switching layouts would install the CRDs from both directories.
+	err := Generate(u, dir, Options{ChartVersion: "1.0.0", CRDs: crds, SplitCRDs: true})
+	if err == nil || !strings.Contains(err.Error(), "other CRD layout") {
+		t.Errorf("switching to split CRDs with crds/ present: %v", err)
+	}
+
+	for _, split := range []bool{false, true} {
+		err := Generate(u, dir, Options{ChartVersion: "1.0.0", SplitCRDs: split})
+		if err == nil || !strings.Contains(err.Error(), "no CRDs given") {
+			t.Errorf("split %v without CRDs: %v", split, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "crds", "widgets.example.com-v1.yml")); err != nil {
+			t.Errorf("split %v without CRDs removed crds/: %v", split, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package chart
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// renameReferences points the Deployments' references to passthrough
+// resources at the names the chart renders them with: the pod's
+// priorityClassName, ConfigMap, Secret and PersistentVolumeClaim volumes, and
+// env and envFrom sources.
+func (u *Upstream) renameReferences() {
+	rendered := make(map[string]string)
+	for _, r := range u.Resources {
+		rendered[r.Kind+"/"+r.OriginalName] = r.RenderedName()
+	}
+	rename := func(m map[string]interface{}, key, kind string) {
+		if name, ok := m[key].(string); ok {
+			if to, ok := rendered[kind+"/"+name]; ok {
+				m[key] = to
+			}
+		}
+	}
+
+	for _, w := range u.Workloads {
+		rename(w.PodSpec, "priorityClassName", "PriorityClass")
+		volumes, _ := w.PodSpec["volumes"].([]interface{})
+		for _, v := range volumes {
+			volume, _ := v.(map[string]interface{})
+			rename(nestedMap(volume, "configMap"), "name", "ConfigMap")
+			rename(nestedMap(volume, "secret"), "secretName", "Secret")
+			rename(nestedMap(volume, "persistentVolumeClaim"), "claimName", "PersistentVolumeClaim")
+			sources, _ := nestedMap(volume, "projected")["sources"].([]interface{})
+			for _, s := range sources {
+				source, _ := s.(map[string]interface{})
+				rename(nestedMap(source, "configMap"), "name", "ConfigMap")
+				rename(nestedMap(source, "secret"), "name", "Secret")
+			}
+		}
+
+		for _, c := range append(append([]Container{}, w.Containers...), w.InitContainers...) {
+			for _, e := range c.Env {
+				if e.ValueFrom == nil {
+					continue
+				}
+				if ref := e.ValueFrom.ConfigMapKeyRef; ref != nil {
+					ref.RenderedName = rendered["ConfigMap/"+ref.Name]
+				}
+				if ref := e.ValueFrom.SecretKeyRef; ref != nil {
+					ref.RenderedName = rendered["Secret/"+ref.Name]
+				}
+			}
+			for _, e := range c.EnvFrom {
+				if ref := e.ConfigMapRef; ref != nil {
+					ref.RenderedName = rendered["ConfigMap/"+ref.Name]
+				}
+				if ref := e.SecretRef; ref != nil {
+					ref.RenderedName = rendered["Secret/"+ref.Name]
+				}
+			}
+		}
+	}
+}
+
+// selectorPlaceholder stands in for the matchLabels of a selector that
+// replaceSelectors replaced, until renderSelectors turns it into the chart's
+// selector labels.
+const selectorPlaceholder = "KEYCLOAK_OPERATOR_SELECTOR_"
+
+var selectorPlaceholderLine = regexp.MustCompile(`(?m)^( *)(matchLabels|selector): ` + selectorPlaceholder + `(\d+)$`)
+
+// replaceSelectors replaces the matchLabels of every podSelector or selector
+// in a passthrough resource that selects the pods of a Deployment, since the
+// chart gives those pods its own labels instead of upstream's. A Service's
+// selector, a plain label map, is replaced as a whole. It returns the selector
+// labels include of each placeholder it left.
+func (u *Upstream) replaceSelectors(v interface{}) []string {
+	var includes []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch n := v.(type) {
+		case map[string]interface{}:
+			for k, child := range n {
+				if k == "podSelector" || k == "selector" {
+					if include := u.selectorInclude(child); include != "" {
+						nestedMap(n, k)["matchLabels"] = selectorPlaceholder + strconv.Itoa(len(includes))
+						includes = append(includes, include)
+						continue
+					}
+					if include := u.labelsInclude(child); k == "selector" && include != "" {
+						n[k] = selectorPlaceholder + strconv.Itoa(len(includes))
+						includes = append(includes, include)
+						continue
+					}
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range n {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	return includes
+}
+
+// selectorInclude returns the selector labels include of the Deployment whose
+// pods a label selector matches, or "" if it matches none. Only selectors
+// with nothing but matchLabels are considered.
+func (u *Upstream) selectorInclude(v interface{}) string {
+	selector, _ := v.(map[string]interface{})
+	if len(selector) != 1 {
+		return ""
+	}
+	return u.labelsInclude(selector["matchLabels"])
+}
+
+// labelsInclude returns the selector labels include of the Deployment whose
+// pods carry all of the labels in v, or "" if there is none.
+func (u *Upstream) labelsInclude(v interface{}) string {
+	labels, _ := v.(map[string]interface{})
+	if len(labels) == 0 {
+		return ""
+	}
+	for _, w := range u.Workloads {
+		podLabels := nestedMap(w.Spec, "template", "metadata", "labels")
+		matches := true
+		for k, v := range labels {
+			if podLabels[k] == nil || scalarString(podLabels[k]) != scalarString(v) {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		if w.Primary {
+			return `include "keycloak-operator.selectorLabels" .`
+		}
+		return fmt.Sprintf(`include "keycloak-operator.workloadSelectorLabels" (dict "context" . "suffix" %q)`, w.Suffix)
+	}
+	return ""
+}
+
+// renderSelectors turns the placeholders replaceSelectors left in marshaled
+// YAML into the selector labels includes.
+func renderSelectors(y string, includes []string) string {
+	return selectorPlaceholderLine.ReplaceAllStringFunc(y, func(line string) string {
+		m := selectorPlaceholderLine.FindStringSubmatch(line)
+		i, _ := strconv.Atoi(m[3])
+		return fmt.Sprintf("%s%s:\n%s  {{- %s | nindent %d }}", m[1], m[2], m[1], includes[i], len(m[1])+2)
+	})
+}
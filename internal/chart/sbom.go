@@ -0,0 +1,187 @@
+package chart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SBOMFile is the chart-relative path of the CycloneDX SBOM, referenced from
+// the Chart.yaml annotations.
+const SBOMFile = "sbom.cdx.json"
+
+// CycloneDX 1.5 JSON, limited to the fields the chart SBOM uses.
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	PURL        string        `json:"purl,omitempty"`
+	Hashes      []cdxHash     `json:"hashes,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Properties recording whether an image is expected to carry a cosign
+// signature and an SBOM attestation, "true" or "false".
+const (
+	signatureProperty   = "px3-dev.github.io:cosign-signature"
+	attestationProperty = "px3-dev.github.io:sbom-attestation"
+)
+
+// sbomJSON builds the CycloneDX SBOM of the chart in chartDir: the chart
+// itself, the Keycloak operator release it packages, every image and every
+// CRD file as written. It has no timestamp or serial number, so that
+// regenerating the same chart gives the same bytes.
+func sbomJSON(d chartData, chartDir string, opts Options) ([]byte, error) {
+	chartRef := "chart:" + Name + "@" + d.ChartVersion
+	appRef := "app:" + Name + "@" + d.AppVersion
+	bom := cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Tools: cdxTools{Components: []cdxComponent{{Type: "application", Name: "keycloak-operator-generate"}}},
+			Component: cdxComponent{
+				Type:    "application",
+				BOMRef:  chartRef,
+				Name:    Name,
+				Version: d.ChartVersion,
+				PURL:    "pkg:helm/" + Name + "@" + d.ChartVersion,
+			},
+		},
+		Components: []cdxComponent{{
+			Type:        "application",
+			BOMRef:      appRef,
+			Name:        Name,
+			Version:     d.AppVersion,
+			Description: "Keycloak operator release packaged by the chart (appVersion)",
+		}},
+	}
+	dependsOn := []string{appRef}
+
+	for _, img := range d.Images() {
+		c := cdxComponent{
+			Type:    "container",
+			BOMRef:  "image:" + img.Image.String(),
+			Name:    img.Image.Name(),
+			Version: img.Image.Tag,
+			PURL:    imagePURL(img.Image),
+			Properties: []cdxProperty{
+				{Name: "helm:values", Value: img.Values},
+				{Name: signatureProperty, Value: matchesImage(opts.SignedImages, img.Image)},
+				{Name: attestationProperty, Value: matchesImage(opts.AttestedImages, img.Image)},
+			},
+		}
+		if alg, sum, ok := strings.Cut(img.Image.Digest, ":"); ok && alg == "sha256" {
+			c.Hashes = []cdxHash{{Alg: "SHA-256", Content: sum}}
+		}
+		bom.Components = append(bom.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+
+	for _, name := range d.crdFiles() {
+		data, err := os.ReadFile(filepath.Join(chartDir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		c := cdxComponent{
+			Type:   "file",
+			BOMRef: "file:" + name,
+			Name:   name,
+			Hashes: []cdxHash{{Alg: "SHA-256", Content: hex.EncodeToString(sum[:])}},
+		}
+		bom.Components = append(bom.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+
+	bom.Dependencies = []cdxDependency{{Ref: chartRef, DependsOn: dependsOn}}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bom); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// crdFiles returns the chart-relative paths the CRDs are written to: crds/,
+// or the templates of the CRD chart when they are split out.
+func (d chartData) crdFiles() []string {
+	var files []string
+	if d.SplitCRDs {
+		for _, crd := range d.CRDInfo {
+			files = append(files, path.Join("charts", CRDChartName, "templates", crd.Name+".yaml"))
+		}
+		return files
+	}
+	for _, crd := range d.CRDs {
+		files = append(files, path.Join("crds", crd.Name))
+	}
+	return files
+}
+
+// matchesImage reports, as "true" or "false", whether the registry and
+// repository of r match one of the patterns.
+func matchesImage(patterns []string, r ImageRef) string {
+	for _, p := range patterns {
+		if globRegexp(p, "$").MatchString(r.Name()) {
+			return "true"
+		}
+	}
+	return "false"
+}
+
+// imagePURL returns the package URL of an OCI image. The digest is the purl
+// version, so an image without one has none.
+func imagePURL(r ImageRef) string {
+	purl := "pkg:oci/" + path.Base(r.Repository)
+	if r.Digest != "" {
+		purl += "@" + strings.ReplaceAll(r.Digest, ":", "%3A")
+	}
+	purl += "?repository_url=" + r.Name()
+	if r.Tag != "" {
+		purl += "&tag=" + r.Tag
+	}
+	return purl
+}
@@ -0,0 +1,78 @@
+package chart
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSBOM(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		crdFile     string
+		signatures  map[string]string // image name → cosign-signature
+		attestation map[string]string // image name → sbom-attestation
+	}{
+		{
+			"no expectations",
+			Options{},
+			"crds/widgets.example.com-v1.yml",
+			map[string]string{"quay.io/keycloak/keycloak-operator": "false", "quay.io/keycloak/keycloak": "false"},
+			map[string]string{"quay.io/keycloak/keycloak-operator": "false", "quay.io/keycloak/keycloak": "false"},
+		},
+		{
+			"expected by pattern",
+			Options{SignedImages: []string{"quay.io/keycloak/*"}, AttestedImages: []string{"quay.io/keycloak/keycloak-operator"}},
+			"crds/widgets.example.com-v1.yml",
+			map[string]string{"quay.io/keycloak/keycloak-operator": "true", "quay.io/keycloak/keycloak": "true"},
+			map[string]string{"quay.io/keycloak/keycloak-operator": "true", "quay.io/keycloak/keycloak": "false"},
+		},
+		{
+			"split CRDs",
+			Options{SplitCRDs: true},
+			"charts/keycloak-operator-crds/templates/widgets.example.com.yaml",
+			map[string]string{"quay.io/keycloak/keycloak-operator": "false", "quay.io/keycloak/keycloak": "false"},
+			map[string]string{"quay.io/keycloak/keycloak-operator": "false", "quay.io/keycloak/keycloak": "false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := generateChart(t, parseManifest(t, operatorManifest), tt.opts)
+			var bom cdxBOM
+			if err := json.Unmarshal([]byte(files[SBOMFile]), &bom); err != nil {
+				t.Fatal(err)
+			}
+
+			signatures, attestations := map[string]string{}, map[string]string{}
+			var crdFiles []string
+			for _, c := range bom.Components {
+				switch c.Type {
+				case "container":
+					for _, p := range c.Properties {
+						switch p.Name {
+						case signatureProperty:
+							signatures[c.Name] = p.Value
+						case attestationProperty:
+							attestations[c.Name] = p.Value
+						}
+					}
+				case "file":
+					crdFiles = append(crdFiles, c.Name)
+					if _, ok := files[c.Name]; !ok {
+						t.Errorf("SBOM lists %s, which is not in the chart", c.Name)
+					}
+				}
+			}
+			if !reflect.DeepEqual(signatures, tt.signatures) {
+				t.Errorf("signatures = %v, want %v", signatures, tt.signatures)
+			}
+			if !reflect.DeepEqual(attestations, tt.attestation) {
+				t.Errorf("attestations = %v, want %v", attestations, tt.attestation)
+			}
+			if !reflect.DeepEqual(crdFiles, []string{tt.crdFile}) {
+				t.Errorf("CRD files = %v, want %s", crdFiles, tt.crdFile)
+			}
+		})
+	}
+}
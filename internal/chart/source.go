@@ -0,0 +1,99 @@
+package chart
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UpstreamManifest and UpstreamCRDs are the files of an upstream release that
+// the chart is generated from.
+const UpstreamManifest = "kubernetes.yml"
+
+var UpstreamCRDs = []string{
+	"keycloaks.k8s.keycloak.org-v1.yml",
+	"keycloakrealmimports.k8s.keycloak.org-v1.yml",
+}
+
+// UpstreamURL returns where upstream publishes a file of a release.
+func UpstreamURL(version, name string) string {
+	return fmt.Sprintf("https://raw.githubusercontent.com/keycloak/keycloak-k8s-resources/%s/kubernetes/%s", version, name)
+}
+
+// upstreamTagsURL lists the tags of the upstream repository, a page at a time.
+const upstreamTagsURL = "https://api.github.com/repos/keycloak/keycloak-k8s-resources/tags?per_page=100&page=%d"
+
+// UpstreamReleases lists the tags of the upstream repository, each of which
+// is a release UpstreamURL can fetch files of.
+func UpstreamReleases() ([]string, error) {
+	var tags []string
+	for page := 1; ; page++ {
+		url := fmt.Sprintf(upstreamTagsURL, page)
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		var batch []struct {
+			Name string `json:"name"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", url, err)
+		}
+		if len(batch) == 0 {
+			return tags, nil
+		}
+		for _, t := range batch {
+			tags = append(tags, t.Name)
+		}
+	}
+}
+
+// httpClient fetches upstream files. Its timeout keeps an unresponsive host
+// from hanging generation.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// Source is an upstream input file. Exactly one of URL and Path is set.
+type Source struct {
+	Name string // base name, as written to crds/
+	URL  string // where it was fetched from
+	Path string // the local file it was read from
+	Data []byte
+}
+
+// ReadSource reads a file from a local path or, for an http(s) URL, fetches
+// it.
+func ReadSource(location string) (Source, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{Name: filepath.Base(location), Path: location, Data: data}, nil
+	}
+
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return Source{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Source{}, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Source{}, fmt.Errorf("GET %s: %w", location, err)
+	}
+	return Source{Name: path.Base(location), URL: location, Data: data}, nil
+}
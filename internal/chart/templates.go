@@ -7,7 +7,7 @@ var chartYAMLTmpl = `apiVersion: v2
 name: keycloak-operator
 description: Keycloak operator for Kubernetes
 type: application
-version: 0.1.0
+version: [[ .ChartVersion ]]
 appVersion: "[[ .AppVersion ]]"
 home: https://www.keycloak.org/operator/installation
 sources:
@@ -15,35 +15,214 @@ sources:
   - https://github.com/px3-dev/keycloak-operator
 maintainers:
   - name: px3-dev
+[[- if .SplitCRDs ]]
+dependencies:
+  - name: [[ crdChartName ]]
+    version: "[[ .ChartVersion ]]"
+    condition: crds.enabled
+[[- end ]]
+annotations:
+  px3-dev.github.io/sbom: [[ sbomFile ]]
+[[- if not .SplitCRDs ]]
+[[- template "crdsRecord" .CRDInfo ]]
+[[- end ]]
+[[- with .Lock ]]
+  px3-dev.github.io/upstream: |
+[[ indent 4 .YAML ]]
+[[- end ]]
+  artifacthub.io/images: |
+[[- range .Images ]]
+    - name: [[ .Name ]]
+      image: [[ .Image ]]
+[[- end ]]
+[[- if not .SplitCRDs ]]
+[[- template "crdsAnnotations" .CRDInfo ]]
+[[- end ]]
+[[- with .Changes ]]
+  artifacthub.io/changes: |
+[[- range . ]]
+    - kind: [[ .Kind ]]
+      description: [[ toYAML .Description ]]
+[[- end ]]
+[[- end ]]
+` + crdAnnotationsTmpl
+
+// crdAnnotationsTmpl describes the CRDs in Chart.yaml annotations, in the
+// operator chart or, with SplitCRDs, the CRD chart.
+var crdAnnotationsTmpl = `
+[[- define "crdsRecord" ]]
+[[- with . ]]
+  px3-dev.github.io/crds: |
+[[- range . ]]
+    - name: [[ .Name ]]
+      versions:
+[[- range .Versions ]]
+        - [[ . ]]
+[[- end ]]
+      digest: [[ .Digest ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- define "crdsAnnotations" ]]
+[[- with . ]]
+  artifacthub.io/crds: |
+[[- range . ]]
+    - kind: [[ .Kind ]]
+      version: [[ .Storage ]]
+      name: [[ .Name ]]
+      displayName: [[ toYAML .DisplayName ]]
+      description: [[ toYAML .Summary ]]
+[[- end ]]
+  artifacthub.io/crdsExamples: |
+[[ indent 4 (crdsExamples .) ]]
+[[- end ]]
+[[- end ]]
 `
 
-var valuesYAMLTmpl = `# Operator image
+var valuesYAMLTmpl = `global:
+  # Registry for every image the chart renders, replacing the registry of
+  # each image below. Use it to pull everything from a single mirror.
+  imageRegistry: ""
+[[- if .SplitCRDs ]]
+
+crds:
+  # Install the CRDs with the [[ crdChartName ]] subchart. Turn off where an
+  # admin installs that chart on its own.
+  enabled: true
+[[- end ]]
+
+# Operator image
 image:
-  repository: [[ .OperatorImage ]]
-  # Defaults to appVersion
-  tag: ""
+[[ indent 2 (imageValues .OperatorImage) ]]
   pullPolicy: IfNotPresent
 
 # Keycloak server image used by the operator when creating instances.
 # The operator injects this as RELATED_IMAGE_KEYCLOAK.
 keycloakImage:
-  repository: [[ .KeycloakImage ]]
-  # Defaults to appVersion
-  tag: ""
+[[ indent 2 (imageValues .KeycloakImage) ]]
+[[- with .RelatedImages ]]
+
+# Other images the operator deploys, injected as RELATED_IMAGE_<NAME>.
+relatedImages:
+[[- range . ]]
+  [[ .ValuesKey ]]:
+    # [[ .EnvName ]]
+[[ indent 4 (imageValues .Image) ]]
+[[- end ]]
+[[- end ]]
 
 imagePullSecrets: []
+
+# Creates a kubernetes.io/dockerconfigjson Secret from these credentials and
+# adds it to imagePullSecrets. registry defaults to global.imageRegistry.
+imagePullCredentials:
+  create: false
+  registry: ""
+  username: ""
+  password: ""
+  email: ""
+
 nameOverride: ""
 fullnameOverride: ""
 
-replicas: [[ .Deployment.Replicas ]]
+replicas: [[ .OperatorWorkload.Replicas ]]
 
 resources:
   requests:
-    cpu: [[ .Deployment.Resources.Requests.CPU ]]
-    memory: [[ .Deployment.Resources.Requests.Memory ]]
+    cpu: [[ .OperatorContainer.Resources.Requests.CPU ]]
+    memory: [[ .OperatorContainer.Resources.Requests.Memory ]]
   limits:
-    cpu: [[ .Deployment.Resources.Limits.CPU ]]
-    memory: [[ .Deployment.Resources.Limits.Memory ]]
+    cpu: [[ .OperatorContainer.Resources.Limits.CPU ]]
+    memory: [[ .OperatorContainer.Resources.Limits.Memory ]]
+[[- with .OperatorContainer ]]
+[[- with .Ports ]]
+[[- with index . 0 ]]
+
+# Operator container port. It is named [[ .Name ]]; the probes and the Service
+# target it by that name, so they follow when it changes.
+containerPort: [[ .ContainerPort ]]
+[[- end ]]
+[[- end ]]
+[[- with .Probes.List ]]
+
+# Operator probes. Every field other than enabled is rendered into the probe,
+# after the upstream handler.
+probes:
+[[- range . ]]
+  [[ .ValuesKey ]]:
+    enabled: true
+[[- with .Probe ]]
+[[- if .FailureThreshold ]]
+    failureThreshold: [[ .FailureThreshold ]]
+[[- end ]]
+[[- if .InitialDelaySeconds ]]
+    initialDelaySeconds: [[ .InitialDelaySeconds ]]
+[[- end ]]
+[[- if .PeriodSeconds ]]
+    periodSeconds: [[ .PeriodSeconds ]]
+[[- end ]]
+[[- if .SuccessThreshold ]]
+    successThreshold: [[ .SuccessThreshold ]]
+[[- end ]]
+[[- if .TimeoutSeconds ]]
+    timeoutSeconds: [[ .TimeoutSeconds ]]
+[[- end ]]
+[[- if .TerminationGracePeriodSeconds ]]
+    terminationGracePeriodSeconds: [[ deref .TerminationGracePeriodSeconds ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- with .ExtraWorkloads ]]
+
+# Additional upstream Deployments, keyed by name. Each has its own pod
+# settings; the top-level ones apply to the operator only.
+workloads:
+[[- range . ]]
+  [[ .ValuesKey ]]:
+    replicas: [[ .Replicas ]]
+    nodeSelector: {}
+    tolerations: []
+    affinity: {}
+    podAnnotations: {}
+    podLabels: {}
+[[- end ]]
+[[- end ]]
+[[- with .ExtraContainers ]]
+
+# Additional upstream containers and initContainers, keyed by name.
+containers:
+[[- range . ]]
+  [[ .ValuesKey ]]:
+    image:
+[[ indent 6 (imageValues .Image) ]]
+      pullPolicy: IfNotPresent
+[[- if .Resources.IsZero ]]
+    resources: {}
+[[- else ]]
+    resources:
+[[- if not .Resources.Requests.IsZero ]]
+      requests:
+[[- if .Resources.Requests.CPU ]]
+        cpu: [[ .Resources.Requests.CPU ]]
+[[- end ]]
+[[- if .Resources.Requests.Memory ]]
+        memory: [[ .Resources.Requests.Memory ]]
+[[- end ]]
+[[- end ]]
+[[- if not .Resources.Limits.IsZero ]]
+      limits:
+[[- if .Resources.Limits.CPU ]]
+        cpu: [[ .Resources.Limits.CPU ]]
+[[- end ]]
+[[- if .Resources.Limits.Memory ]]
+        memory: [[ .Resources.Limits.Memory ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
 
 serviceAccount:
   create: true
@@ -51,15 +230,61 @@ serviceAccount:
   # If not set and create is true, a name is generated using the fullname template.
   name: ""
 
+clusterScoped:
+  # Render ClusterRoles, ClusterRoleBindings and other cluster-scoped
+  # resources. Set to false to install with permissions in the release
+  # namespace only: ClusterRoles that RoleBindings reference become Roles,
+  # without the rules only a ClusterRole can grant. NOTES.txt then lists
+  # what a cluster admin has to install beforehand.
+  enabled: true
+
 service:
   type: [[ .Service.Type ]]
-  port: [[ .Service.Port ]]
+[[- with .Service.Ports ]]
+  port: [[ (index . 0).Port ]]
+[[- end ]]
+  # Additional Service ports, rendered after the upstream ones.
+  extraPorts: []
 
 nodeSelector: {}
 tolerations: []
 affinity: {}
 podAnnotations: {}
 podLabels: {}
+[[- with .Resources ]]
+
+# Upstream resources without a dedicated template, rendered from
+# templates/upstream/ with the chart's name and labels.
+upstream:
+[[- range . ]]
+  [[ .ValuesKey ]]:
+    # [[ .Kind ]] [[ .OriginalName ]][[ if .ClusterScoped ]] (cluster-scoped)[[ end ]]
+    enabled: true
+[[- end ]]
+[[- end ]]
+`
+
+// The chart's README, which Artifact Hub shows on the package page, with the
+// install commands for the Helm repository and the OCI registry.
+var chartReadmeTmpl = "# keycloak-operator\n" + `
+Helm chart for the [Keycloak Kubernetes operator](https://www.keycloak.org/operator/installation) [[ .AppVersion ]], generated from the upstream manifests.
+
+## Install
+
+From the Helm repository:
+
+` + "```" + `bash
+helm repo add px3-dev https://px3-dev.github.io/keycloak-operator
+helm install keycloak-operator px3-dev/keycloak-operator --version [[ .ChartVersion ]] -n keycloak --create-namespace
+` + "```" + `
+
+From the OCI registry:
+
+` + "```" + `bash
+helm install keycloak-operator oci://ghcr.io/px3-dev/charts/keycloak-operator --version [[ .ChartVersion ]] -n keycloak --create-namespace
+` + "```" + `
+
+The values are documented in values.yaml and at https://github.com/px3-dev/keycloak-operator.
 `
 
 var helmignoreContent = `# Patterns to ignore when packaging Helm charts.
@@ -127,6 +352,70 @@ app.kubernetes.io/name: {{ include "keycloak-operator.name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{/*
+Image reference from an image values block (registry, repository, tag,
+digest). A digest takes precedence over the tag, and the tag defaults to
+appVersion. A repository that already starts with a registry host, as in
+chart versions before registry was split out, overrides registry.
+global.imageRegistry, when set, replaces the registry of every image.
+*/}}
+{{- define "keycloak-operator.image" -}}
+{{- $registry := .image.registry }}
+{{- $repository := .image.repository }}
+{{- $host := regexFind "^[^/]+/" $repository | trimSuffix "/" }}
+{{- if or (contains "." $host) (contains ":" $host) (eq $host "localhost") }}
+{{- $registry = $host }}
+{{- $repository = trimPrefix (printf "%s/" $host) $repository }}
+{{- end }}
+{{- with .context.Values.global.imageRegistry }}
+{{- $registry = . }}
+{{- end }}
+{{- if $registry }}
+{{- $repository = printf "%s/%s" $registry $repository }}
+{{- end }}
+{{- if .image.digest }}
+{{- printf "%s@%s" $repository .image.digest }}
+{{- else }}
+{{- printf "%s:%s" $repository (.image.tag | default .context.Chart.AppVersion) }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the Secret created from imagePullCredentials
+*/}}
+{{- define "keycloak-operator.imagePullSecretName" -}}
+{{- printf "%s-pull-secret" (include "keycloak-operator.fullname" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Pod imagePullSecrets: imagePullSecrets plus the Secret created from
+imagePullCredentials, as YAML. Empty when there are none.
+*/}}
+{{- define "keycloak-operator.imagePullSecrets" -}}
+{{- $secrets := .Values.imagePullSecrets | default list }}
+{{- if .Values.imagePullCredentials.create }}
+{{- $secrets = append $secrets (dict "name" (include "keycloak-operator.imagePullSecretName" .)) }}
+{{- end }}
+{{- with $secrets }}
+{{- toYaml . }}
+{{- end }}
+{{- end }}
+
+{{/*
+.dockerconfigjson content for imagePullCredentials. The registry defaults to
+global.imageRegistry.
+*/}}
+{{- define "keycloak-operator.dockerconfigjson" -}}
+{{- with .Values.imagePullCredentials }}
+{{- $registry := .registry | default $.Values.global.imageRegistry | required "imagePullCredentials.registry or global.imageRegistry is required" }}
+{{- $auth := dict "username" (required "imagePullCredentials.username is required" .username) "password" (required "imagePullCredentials.password is required" .password) "auth" (printf "%s:%s" .username .password | b64enc) }}
+{{- with .email }}
+{{- $_ := set $auth "email" . }}
+{{- end }}
+{{- dict "auths" (dict $registry $auth) | toJson }}
+{{- end }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
@@ -137,26 +426,101 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+[[- if .ExtraWorkloads ]]
+
+{{/*
+Common labels of an additional upstream workload.
+Takes a dict with "context" (the root context) and "suffix".
+*/}}
+{{- define "keycloak-operator.workloadLabels" -}}
+helm.sh/chart: {{ include "keycloak-operator.chart" .context }}
+{{ include "keycloak-operator.workloadSelectorLabels" . }}
+{{- if .context.Chart.AppVersion }}
+app.kubernetes.io/version: {{ .context.Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .context.Release.Service }}
+{{- end }}
+
+{{/*
+Selector labels of an additional upstream workload.
+The name differs from the operator's so the Deployment selectors never overlap.
+*/}}
+{{- define "keycloak-operator.workloadSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "keycloak-operator.name" .context }}-{{ .suffix }}
+app.kubernetes.io/instance: {{ .context.Release.Name }}
+{{- end }}
+[[- end ]]
 `
 
 var notesContent = `Keycloak Operator {{ .Chart.AppVersion }} has been installed.
 
 The operator is watching namespace {{ .Release.Namespace }} for Keycloak and KeycloakRealmImport resources.
+[[- range .CRDInfo ]]
 
-To create a Keycloak instance, apply a Keycloak CR:
+A minimal [[ .Kind ]], synthesized from its schema:
 
   kubectl apply -n {{ .Release.Namespace }} -f - <<EOF
-  apiVersion: k8s.keycloak.org/v2alpha1
-  kind: Keycloak
-  metadata:
-    name: my-keycloak
-  spec:
-    instances: 1
-    hostname:
-      hostname: my-keycloak.example.com
-    http:
-      tlsSecret: my-tls-secret
+[[ indent 2 (exampleYAML .) ]]
   EOF
+[[- with hintPaths . ]]
+
+Set [[ range $i, $p := . ]][[ if $i ]], [[ end ]][[ $p ]][[ end ]] to your own values first.
+[[- end ]]
+[[- end ]]
+{{- if not .Values.clusterScoped.enabled }}
+
+clusterScoped.enabled is false, so no cluster-scoped resources were installed.
+A cluster admin has to set these up beforehand:
+[[- with .CRDInfo ]]
+
+  - The CRDs [[ range $i, $c := . ]][[ if $i ]], [[ end ]][[ $c.Name ]][[ end ]].
+[[- if $.SplitCRDs ]]
+    Install the [[ crdChartName ]] chart and this one with crds.enabled=false.
+[[- else ]]
+    Install this chart with --skip-crds.
+[[- end ]]
+[[- end ]]
+[[- range .ClusterGrants ]]
+
+  - A ClusterRoleBinding of ServiceAccount {{ include "keycloak-operator.serviceAccountName" . }}
+    in namespace {{ .Release.Namespace }} to
+[[- if .RoleName ]] the [[ .RoleName ]] ClusterRole.
+[[- else ]] a ClusterRole granting:
+[[- range .Rules ]]
+      [[ . ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- range $role := .NamespacedRoles ]]
+[[- if $role.Skipped ]]
+
+  - A ClusterRoleBinding of the same ServiceAccount to a ClusterRole granting
+    these rules of {{ include "keycloak-operator.fullname" . }}-[[ $role.Suffix ]], which a Role cannot grant:
+[[- range $role.Skipped ]]
+      [[ . ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- range .Resources ]]
+[[- if .ClusterScoped ]]
+
+  - The [[ .Kind ]] [[ .OriginalName ]].
+[[- end ]]
+[[- end ]]
+{{- end }}
+`
+
+var imagePullSecretContent = `{{- if .Values.imagePullCredentials.create -}}
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ include "keycloak-operator.imagePullSecretName" . }}
+  labels:
+    {{- include "keycloak-operator.labels" . | nindent 4 }}
+type: kubernetes.io/dockerconfigjson
+data:
+  .dockerconfigjson: {{ include "keycloak-operator.dockerconfigjson" . | b64enc }}
+{{- end }}
 `
 
 var serviceAccountTmpl = `{{- if .Values.serviceAccount.create -}}
@@ -173,108 +537,286 @@ metadata:
 {{- end }}
 `
 
-var deploymentTmpl = `apiVersion: apps/v1
+var deploymentTmpl = `[[ range $i, $w := .Workloads ]][[ if $i ]]---
+[[ end ]]apiVersion: apps/v1
 kind: Deployment
 metadata:
+[[- if $w.Primary ]]
   name: {{ include "keycloak-operator.fullname" . }}
+[[- else ]]
+  name: {{ include "keycloak-operator.fullname" . }}-[[ $w.Suffix ]]
+[[- end ]]
   labels:
+[[- if $w.Primary ]]
     {{- include "keycloak-operator.labels" . | nindent 4 }}
+[[- else ]]
+    {{- include "keycloak-operator.workloadLabels" (dict "context" . "suffix" "[[ $w.Suffix ]]") | nindent 4 }}
+[[- end ]]
 spec:
+[[- if $w.Primary ]]
   replicas: {{ .Values.replicas }}
+[[- else ]]
+  replicas: {{ .Values.workloads.[[ $w.ValuesKey ]].replicas }}
+[[- end ]]
   selector:
     matchLabels:
+[[- if $w.Primary ]]
       {{- include "keycloak-operator.selectorLabels" . | nindent 6 }}
+[[- else ]]
+      {{- include "keycloak-operator.workloadSelectorLabels" (dict "context" . "suffix" "[[ $w.Suffix ]]") | nindent 6 }}
+[[- end ]]
+[[- with $w.SpecPassthroughYAML ]]
+[[ indent 2 . ]]
+[[- end ]]
   template:
     metadata:
-      {{- with .Values.podAnnotations }}
+      {{- with [[ template "podValues" $w ]].podAnnotations }}
       annotations:
         {{- toYaml . | nindent 8 }}
       {{- end }}
       labels:
+[[- if $w.Primary ]]
         {{- include "keycloak-operator.labels" . | nindent 8 }}
-        {{- with .Values.podLabels }}
+[[- else ]]
+        {{- include "keycloak-operator.workloadLabels" (dict "context" . "suffix" "[[ $w.Suffix ]]") | nindent 8 }}
+[[- end ]]
+        {{- with [[ template "podValues" $w ]].podLabels }}
         {{- toYaml . | nindent 8 }}
         {{- end }}
     spec:
-      {{- with .Values.imagePullSecrets }}
+      {{- with include "keycloak-operator.imagePullSecrets" . }}
       imagePullSecrets:
-        {{- toYaml . | nindent 8 }}
+        {{- . | nindent 8 }}
       {{- end }}
       serviceAccountName: {{ include "keycloak-operator.serviceAccountName" . }}
+[[- with $w.PodSpecPassthroughYAML ]]
+[[ indent 6 . ]]
+[[- end ]]
+[[- with $w.InitContainers ]]
+      initContainers:
+[[- range . ]]
+[[- template "container" . ]]
+[[- end ]]
+[[- end ]]
       containers:
-        - name: [[ .Deployment.ContainerName ]]
-          image: "{{ .Values.image.repository }}:{{ .Values.image.tag | default .Chart.AppVersion }}"
+[[- range $w.Containers ]]
+[[- template "container" . ]]
+[[- end ]]
+      {{- with [[ template "podValues" $w ]].nodeSelector }}
+      nodeSelector:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with [[ template "podValues" $w ]].affinity }}
+      affinity:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with [[ template "podValues" $w ]].tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+[[ end ]]
+[[- define "podValues" ]]
+[[- if .Primary ]].Values[[ else ]].Values.workloads.[[ .ValuesKey ]][[ end ]]
+[[- end ]]
+[[- define "container" ]]
+        - name: [[ .Name ]]
+[[- if .Primary ]]
+          image: {{ include "keycloak-operator.image" (dict "image" .Values.image "context" .) | quote }}
+[[- else ]]
+          image: {{ include "keycloak-operator.image" (dict "image" .Values.containers.[[ .ValuesKey ]].image "context" .) | quote }}
+[[- end ]]
+[[- if .Primary ]]
           imagePullPolicy: {{ .Values.image.pullPolicy }}
+[[- else ]]
+          imagePullPolicy: {{ .Values.containers.[[ .ValuesKey ]].image.pullPolicy }}
+[[- end ]]
+[[- with .Env ]]
           env:
-            - name: KUBERNETES_NAMESPACE
+[[- range . ]]
+            - name: [[ .Name ]]
+[[- if .ImageValues ]]
+              value: {{ include "keycloak-operator.image" (dict "image" .Values.[[ .ImageValues ]] "context" .) | quote }}
+[[- else if .ValueFrom ]]
               valueFrom:
+[[- with .ValueFrom.FieldRef ]]
                 fieldRef:
-                  fieldPath: metadata.namespace
-            - name: RELATED_IMAGE_KEYCLOAK
-              value: "{{ .Values.keycloakImage.repository }}:{{ .Values.keycloakImage.tag | default .Chart.AppVersion }}"
-[[- range .Deployment.ExtraEnv ]]
-            - name: [[ .Name ]]
-              value: [[ .Value ]]
+[[- if .APIVersion ]]
+                  apiVersion: [[ .APIVersion ]]
+[[- end ]]
+                  fieldPath: [[ .FieldPath ]]
+[[- end ]]
+[[- with .ValueFrom.ResourceFieldRef ]]
+                resourceFieldRef:
+[[- if .ContainerName ]]
+                  containerName: [[ .ContainerName ]]
+[[- end ]]
+                  resource: [[ .Resource ]]
+[[- if .Divisor ]]
+                  divisor: [[ yamlString .Divisor ]]
+[[- end ]]
+[[- end ]]
+[[- with .ValueFrom.ConfigMapKeyRef ]]
+                configMapKeyRef:
+                  name: [[ template "refName" . ]]
+                  key: [[ yamlString .Key ]]
+[[- if .Optional ]]
+                  optional: [[ deref .Optional ]]
+[[- end ]]
+[[- end ]]
+[[- with .ValueFrom.SecretKeyRef ]]
+                secretKeyRef:
+                  name: [[ template "refName" . ]]
+                  key: [[ yamlString .Key ]]
+[[- if .Optional ]]
+                  optional: [[ deref .Optional ]]
+[[- end ]]
+[[- end ]]
+[[- else ]]
+              value: [[ yamlString .Value ]]
+[[- end ]]
+[[- end ]]
 [[- end ]]
+[[- with .EnvFrom ]]
+          envFrom:
+[[- range . ]]
+[[- if .ConfigMapRef ]]
+            - configMapRef:
+                name: [[ template "refName" .ConfigMapRef ]]
+[[- if .ConfigMapRef.Optional ]]
+                optional: [[ deref .ConfigMapRef.Optional ]]
+[[- end ]]
+[[- else ]]
+            - secretRef:
+                name: [[ template "refName" .SecretRef ]]
+[[- if .SecretRef.Optional ]]
+                optional: [[ deref .SecretRef.Optional ]]
+[[- end ]]
+[[- end ]]
+[[- if .Prefix ]]
+              prefix: [[ yamlString .Prefix ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- with .Ports ]]
           ports:
-            - name: http
-              containerPort: [[ .Deployment.ContainerPort ]]
-              protocol: TCP
-[[- if .Deployment.Probes.Liveness.Path ]]
-          livenessProbe:
-            httpGet:
-              path: [[ .Deployment.Probes.Liveness.Path ]]
-              port: http
-              scheme: HTTP
-            failureThreshold: [[ .Deployment.Probes.Liveness.FailureThreshold ]]
-            initialDelaySeconds: [[ .Deployment.Probes.Liveness.InitialDelaySeconds ]]
-            periodSeconds: [[ .Deployment.Probes.Liveness.PeriodSeconds ]]
-            successThreshold: [[ .Deployment.Probes.Liveness.SuccessThreshold ]]
-            timeoutSeconds: [[ .Deployment.Probes.Liveness.TimeoutSeconds ]]
-[[- end ]]
-[[- if .Deployment.Probes.Readiness.Path ]]
-          readinessProbe:
-            httpGet:
-              path: [[ .Deployment.Probes.Readiness.Path ]]
-              port: http
-              scheme: HTTP
-            failureThreshold: [[ .Deployment.Probes.Readiness.FailureThreshold ]]
-            initialDelaySeconds: [[ .Deployment.Probes.Readiness.InitialDelaySeconds ]]
-            periodSeconds: [[ .Deployment.Probes.Readiness.PeriodSeconds ]]
-            successThreshold: [[ .Deployment.Probes.Readiness.SuccessThreshold ]]
-            timeoutSeconds: [[ .Deployment.Probes.Readiness.TimeoutSeconds ]]
-[[- end ]]
-[[- if .Deployment.Probes.Startup.Path ]]
-          startupProbe:
-            httpGet:
-              path: [[ .Deployment.Probes.Startup.Path ]]
-              port: http
-              scheme: HTTP
-            failureThreshold: [[ .Deployment.Probes.Startup.FailureThreshold ]]
-            initialDelaySeconds: [[ .Deployment.Probes.Startup.InitialDelaySeconds ]]
-            periodSeconds: [[ .Deployment.Probes.Startup.PeriodSeconds ]]
-            successThreshold: [[ .Deployment.Probes.Startup.SuccessThreshold ]]
-            timeoutSeconds: [[ .Deployment.Probes.Startup.TimeoutSeconds ]]
+[[- range $i, $p := . ]]
+[[- if $p.Name ]]
+            - name: [[ $p.Name ]]
+              containerPort:
+[[- else ]]
+            - containerPort:
+[[- end ]]
+[[- if and $.Primary (eq $i 0) ]] {{ .Values.containerPort }}
+[[- else ]] [[ $p.ContainerPort ]]
+[[- end ]]
+[[- if $p.Protocol ]]
+              protocol: [[ $p.Protocol ]]
+[[- end ]]
+[[- if $p.HostPort ]]
+              hostPort: [[ $p.HostPort ]]
+[[- end ]]
+[[- if $p.HostIP ]]
+              hostIP: [[ yamlString $p.HostIP ]]
 [[- end ]]
+[[- end ]]
+[[- end ]]
+[[- if .Primary ]]
+[[- range .Probes.List ]]
+          {{- if .Values.probes.[[ .ValuesKey ]].enabled }}
+          [[ .Field ]]:
+[[- template "probeHandler" .Probe ]]
+            {{- with omit .Values.probes.[[ .ValuesKey ]] "enabled" }}
+            {{- toYaml . | nindent 12 }}
+            {{- end }}
+          {{- end }}
+[[- end ]]
+[[- else ]]
+[[- range .Probes.List ]]
+          [[ .Field ]]:
+[[- template "probeHandler" .Probe ]]
+[[- template "probeTimings" .Probe ]]
+[[- end ]]
+[[- end ]]
+[[- if .Primary ]]
           {{- with .Values.resources }}
+[[- else ]]
+          {{- with .Values.containers.[[ .ValuesKey ]].resources }}
+[[- end ]]
           resources:
             {{- toYaml . | nindent 12 }}
           {{- end }}
-      {{- with .Values.nodeSelector }}
-      nodeSelector:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-      {{- with .Values.affinity }}
-      affinity:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-      {{- with .Values.tolerations }}
-      tolerations:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-`
+[[- with .PassthroughYAML ]]
+[[ indent 10 . ]]
+[[- end ]]
+[[- end ]]
+[[- define "probeHandler" ]]
+[[- with .HTTPGet ]]
+            httpGet:
+[[- if .Path ]]
+              path: [[ yamlString .Path ]]
+[[- end ]]
+              port: [[ .Port ]]
+[[- if .Host ]]
+              host: [[ yamlString .Host ]]
+[[- end ]]
+[[- if .Scheme ]]
+              scheme: [[ .Scheme ]]
+[[- end ]]
+[[- with .HTTPHeaders ]]
+              httpHeaders:
+[[- range . ]]
+                - name: [[ yamlString .Name ]]
+                  value: [[ yamlString .Value ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- with .TCPSocket ]]
+            tcpSocket:
+              port: [[ .Port ]]
+[[- if .Host ]]
+              host: [[ yamlString .Host ]]
+[[- end ]]
+[[- end ]]
+[[- with .Exec ]]
+            exec:
+              command:
+[[- range .Command ]]
+                - [[ yamlString . ]]
+[[- end ]]
+[[- end ]]
+[[- with .GRPC ]]
+            grpc:
+              port: [[ .Port ]]
+[[- if .Service ]]
+              service: [[ yamlString (deref .Service) ]]
+[[- end ]]
+[[- end ]]
+[[- end ]]
+[[- define "refName" ]]
+[[- if .RenderedName ]][[ .RenderedName ]][[ else ]][[ yamlString .Name ]][[ end ]]
+[[- end ]]
+[[- define "probeTimings" ]]
+[[- if .FailureThreshold ]]
+            failureThreshold: [[ .FailureThreshold ]]
+[[- end ]]
+[[- if .InitialDelaySeconds ]]
+            initialDelaySeconds: [[ .InitialDelaySeconds ]]
+[[- end ]]
+[[- if .PeriodSeconds ]]
+            periodSeconds: [[ .PeriodSeconds ]]
+[[- end ]]
+[[- if .SuccessThreshold ]]
+            successThreshold: [[ .SuccessThreshold ]]
+[[- end ]]
+[[- if .TimeoutSeconds ]]
+            timeoutSeconds: [[ .TimeoutSeconds ]]
+[[- end ]]
+[[- if .TerminationGracePeriodSeconds ]]
+            terminationGracePeriodSeconds: [[ deref .TerminationGracePeriodSeconds ]]
+[[- end ]]
+[[- end ]]`
 
-var serviceContent = `apiVersion: v1
+var serviceTmpl = `apiVersion: v1
 kind: Service
 metadata:
   name: {{ include "keycloak-operator.fullname" . }}
@@ -283,15 +825,39 @@ metadata:
 spec:
   type: {{ .Values.service.type }}
   ports:
+[[- range $i, $p := .Service.Ports ]]
+[[- if eq $i 0 ]]
     - port: {{ .Values.service.port }}
-      targetPort: http
-      protocol: TCP
-      name: http
+[[- else ]]
+    - port: [[ $p.Port ]]
+[[- end ]]
+[[- if $p.TargetPort ]]
+      targetPort: [[ $p.TargetPort ]]
+[[- end ]]
+[[- if $p.Protocol ]]
+      protocol: [[ $p.Protocol ]]
+[[- end ]]
+[[- if $p.Name ]]
+      name: [[ $p.Name ]]
+[[- end ]]
+[[- if $p.NodePort ]]
+      nodePort: [[ $p.NodePort ]]
+[[- end ]]
+[[- if $p.AppProtocol ]]
+      appProtocol: [[ yamlString $p.AppProtocol ]]
+[[- end ]]
+[[- end ]]
+    {{- with .Values.service.extraPorts }}
+    {{- toYaml . | nindent 4 }}
+    {{- end }}
   selector:
     {{- include "keycloak-operator.selectorLabels" . | nindent 4 }}
 `
 
-var clusterRoleTmpl = `[[- range $i, $role := .RBAC.ClusterRoles ]]
+var clusterRoleTmpl = `[[- with .RBAC.ClusterRoles ]]
+{{- if .Values.clusterScoped.enabled }}
+[[- end ]]
+[[- range $i, $role := .RBAC.ClusterRoles ]]
 [[- if $i ]]
 ---
 [[- end ]]
@@ -304,9 +870,15 @@ metadata:
 rules:
 [[ indent 2 $role.RulesYAML ]]
 [[- end ]]
+[[- with .RBAC.ClusterRoles ]]
+{{- end }}
+[[- end ]]
 `
 
-var clusterRoleBindingTmpl = `[[- range $i, $binding := .RBAC.ClusterRoleBindings ]]
+var clusterRoleBindingTmpl = `[[- with .RBAC.ClusterRoleBindings ]]
+{{- if .Values.clusterScoped.enabled }}
+[[- end ]]
+[[- range $i, $binding := .RBAC.ClusterRoleBindings ]]
 [[- if $i ]]
 ---
 [[- end ]]
@@ -329,6 +901,9 @@ subjects:
     name: {{ include "keycloak-operator.serviceAccountName" . }}
     namespace: {{ .Release.Namespace }}
 [[- end ]]
+[[- with .RBAC.ClusterRoleBindings ]]
+{{- end }}
+[[- end ]]
 `
 
 var roleTmpl = `[[- range $i, $role := .RBAC.Roles ]]
@@ -344,12 +919,37 @@ metadata:
 rules:
 [[ indent 2 $role.RulesYAML ]]
 [[- end ]]
+[[- with .NamespacedRoles ]]
+{{- if not .Values.clusterScoped.enabled }}
+[[- range . ]]
+[[- if .RulesYAML ]]
+---
+# Narrowed from the [[ .Suffix ]] ClusterRole.
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{ include "keycloak-operator.fullname" . }}-[[ .Suffix ]]
+  labels:
+    {{- include "keycloak-operator.labels" . | nindent 4 }}
+rules:
+[[ indent 2 .RulesYAML ]]
+[[- end ]]
+[[- end ]]
+{{- end }}
+[[- end ]]
 `
 
 var roleBindingTmpl = `[[- range $i, $binding := .RBAC.RoleBindings ]]
 [[- if $i ]]
 ---
 [[- end ]]
+[[- $narrowed := "" ]]
+[[- if and (not $binding.IsBuiltinRole) (eq $binding.RoleRefKind "ClusterRole") ]]
+[[- with $.NamespacedRole $binding.RoleSuffix ]][[ $narrowed = .RulesYAML ]][[ end ]]
+[[- if not $narrowed ]]
+{{- if .Values.clusterScoped.enabled }}
+[[- end ]]
+[[- end ]]
 apiVersion: rbac.authorization.k8s.io/v1
 kind: RoleBinding
 metadata:
@@ -365,6 +965,10 @@ roleRef:
   kind: Role
   apiGroup: rbac.authorization.k8s.io
   name: {{ include "keycloak-operator.fullname" . }}-[[ $binding.RoleSuffix ]]
+[[- else if $narrowed ]]
+  kind: {{ if .Values.clusterScoped.enabled }}ClusterRole{{ else }}Role{{ end }}
+  apiGroup: rbac.authorization.k8s.io
+  name: {{ include "keycloak-operator.fullname" . }}-[[ $binding.RoleSuffix ]]
 [[- else ]]
   kind: ClusterRole
   apiGroup: rbac.authorization.k8s.io
@@ -373,5 +977,88 @@ roleRef:
 subjects:
   - kind: ServiceAccount
     name: {{ include "keycloak-operator.serviceAccountName" . }}
+[[- if and (not $binding.IsBuiltinRole) (eq $binding.RoleRefKind "ClusterRole") (not $narrowed) ]]
+{{- end }}
+[[- end ]]
+[[- end ]]
+`
+
+var upstreamResourceTmpl = `{{- if [[ if .ClusterScoped ]]and .Values.clusterScoped.enabled [[ end ]].Values.upstream.[[ .ValuesKey ]].enabled }}
+apiVersion: [[ .APIVersion ]]
+kind: [[ .Kind ]]
+metadata:
+  name: [[ .RenderedName ]]
+  labels:
+    {{- include "keycloak-operator.labels" . | nindent 4 }}
+[[- with .AnnotationsYAML ]]
+  annotations:
+[[ indent 4 . ]]
+[[- end ]]
+[[- with .BodyYAML ]]
+[[ . ]]
+[[- end ]]
+{{- end }}
+`
+
+// The CRD chart, generated instead of crds/ when CRDs are split out. Its
+// templates are rendered from chartData like the operator chart's.
+
+var crdChartYAMLTmpl = `apiVersion: v2
+name: [[ crdChartName ]]
+description: CustomResourceDefinitions of the Keycloak operator
+type: application
+version: [[ .ChartVersion ]]
+appVersion: "[[ .AppVersion ]]"
+home: https://www.keycloak.org/operator/installation
+sources:
+  - https://github.com/keycloak/keycloak-k8s-resources
+  - https://github.com/px3-dev/keycloak-operator
+maintainers:
+  - name: px3-dev
+[[- with .CRDInfo ]]
+annotations:
+[[- template "crdsRecord" . ]]
+[[- template "crdsAnnotations" . ]]
+[[- end ]]
+` + crdAnnotationsTmpl
+
+var crdValuesYAMLContent = `# Keep the CRDs, and with them every custom resource, when the release is
+# uninstalled.
+keep: true
+`
+
+var crdHelpersContent = `{{/*
+Common labels
+*/}}
+{{- define "keycloak-operator-crds.labels" -}}
+helm.sh/chart: {{ printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" }}
+app.kubernetes.io/name: {{ .Chart.Name }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+app.kubernetes.io/part-of: keycloak-operator
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+`
+
+var crdTmpl = `apiVersion: [[ .APIVersion ]]
+kind: CustomResourceDefinition
+metadata:
+  name: [[ .Name ]]
+  labels:
+    {{- include "keycloak-operator-crds.labels" . | nindent 4 }}
+[[- if .AnnotationsYAML ]]
+  annotations:
+[[ indent 4 .AnnotationsYAML ]]
+    {{- if .Values.keep }}
+    helm.sh/resource-policy: keep
+    {{- end }}
+[[- else ]]
+  {{- if .Values.keep }}
+  annotations:
+    helm.sh/resource-policy: keep
+  {{- end }}
 [[- end ]]
+[[ .BodyYAML ]]
 `
@@ -1,22 +1,167 @@
 package chart
 
+import "strings"
+
 // Upstream holds all data extracted from the upstream Keycloak operator manifests.
 type Upstream struct {
 	AppVersion    string
-	OperatorImage string
-	KeycloakImage string
-	Deployment    DeploymentData
+	OperatorImage ImageRef
+	KeycloakImage ImageRef
+	RelatedImages []RelatedImage
+	Workloads     []Workload
 	Service       ServiceData
 	RBAC          RBACData
+	Resources     []Resource
+
+	// Drops lists upstream resources and fields that the chart does not
+	// render, in manifest order.
+	Drops []Drop
 }
 
-type DeploymentData struct {
-	Replicas      int
-	ContainerName string
-	ContainerPort int
-	Resources     ResourceRequirements
-	Probes        ProbeConfig
-	ExtraEnv      []StaticEnvVar
+// OperatorWorkload returns the Deployment that runs the operator.
+func (u *Upstream) OperatorWorkload() *Workload {
+	for i := range u.Workloads {
+		if u.Workloads[i].Primary {
+			return &u.Workloads[i]
+		}
+	}
+	return nil
+}
+
+// OperatorContainer returns the operator container of the operator Deployment.
+func (u *Upstream) OperatorContainer() *Container {
+	w := u.OperatorWorkload()
+	if w == nil {
+		return nil
+	}
+	for i := range w.Containers {
+		if w.Containers[i].Primary {
+			return &w.Containers[i]
+		}
+	}
+	return nil
+}
+
+// ExtraWorkloads returns every Deployment other than the operator's.
+func (u *Upstream) ExtraWorkloads() []Workload {
+	var out []Workload
+	for _, w := range u.Workloads {
+		if !w.Primary {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// ExtraContainers returns every container and initContainer, across all
+// workloads, that has its own entry under containers.<ValuesKey>.
+func (u *Upstream) ExtraContainers() []Container {
+	var out []Container
+	for _, w := range u.Workloads {
+		for _, c := range w.InitContainers {
+			out = append(out, c)
+		}
+		for _, c := range w.Containers {
+			if !c.Primary {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// RelatedImage is an image the operator deploys, passed to it as a
+// RELATED_IMAGE_<NAME> env var and overridable under relatedImages.<ValuesKey>.
+// RELATED_IMAGE_KEYCLOAK is not one of them; it keeps the keycloakImage values.
+type RelatedImage struct {
+	EnvName   string
+	ValuesKey string
+	Image     ImageRef
+}
+
+// ChartImage is an entry of the chart's image inventory: an image the chart
+// can deploy, with the values block it is rendered from.
+type ChartImage struct {
+	Name   string
+	Values string
+	Image  ImageRef
+}
+
+// Images returns every image the chart can deploy with its default values:
+// the operator, the Keycloak server, the other related images and the
+// remaining containers, in that order.
+func (u *Upstream) Images() []ChartImage {
+	out := []ChartImage{{Name: "keycloak-operator", Values: "image", Image: u.OperatorImage}}
+	if u.KeycloakImage.Repository != "" {
+		out = append(out, ChartImage{Name: "keycloak", Values: "keycloakImage", Image: u.KeycloakImage})
+	}
+	for _, r := range u.RelatedImages {
+		out = append(out, ChartImage{Name: r.Name(), Values: "relatedImages." + r.ValuesKey, Image: r.Image})
+	}
+	for _, c := range u.ExtraContainers() {
+		out = append(out, ChartImage{Name: c.Name, Values: "containers." + c.ValuesKey + ".image", Image: c.Image})
+	}
+	return out
+}
+
+// Name returns the image's name in the inventory: the env var suffix in
+// lowercase, with dashes for underscores.
+func (r RelatedImage) Name() string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(r.EnvName, relatedImagePrefix)), "_", "-")
+}
+
+// Workload is an upstream Deployment. The first one is the operator itself and
+// keeps the chart's top-level values; any others are keyed by Suffix.
+type Workload struct {
+	OriginalName   string
+	Suffix         string
+	ValuesKey      string
+	Primary        bool
+	Replicas       int
+	Containers     []Container
+	InitContainers []Container
+
+	// Spec and PodSpec are the upstream Deployment and pod specs, kept whole
+	// so that fields the chart does not model can be rendered verbatim.
+	Spec    map[string]interface{}
+	PodSpec map[string]interface{}
+}
+
+// SpecPassthroughYAML returns the Deployment spec fields the chart does not
+// model, such as strategy, as YAML.
+func (w Workload) SpecPassthroughYAML() (string, error) {
+	return passthroughYAML(w.Spec, modelledDeploymentFields)
+}
+
+// PodSpecPassthroughYAML returns the pod spec fields the chart does not model,
+// such as securityContext or volumes, as YAML.
+func (w Workload) PodSpecPassthroughYAML() (string, error) {
+	return passthroughYAML(w.PodSpec, modelledPodFields)
+}
+
+// Container is a single container or initContainer of a Workload. The
+// operator container reads its image and resources from the top-level values;
+// every other container has its own entry under containers.<ValuesKey>.
+type Container struct {
+	Name      string
+	Image     ImageRef
+	ValuesKey string
+	Primary   bool
+	Ports     []ContainerPort
+	Resources ResourceRequirements
+	Probes    ProbeConfig
+	Env       []EnvVar
+	EnvFrom   []EnvFromSource
+
+	// Spec is the upstream container, kept whole so that fields the chart
+	// does not model can be rendered verbatim.
+	Spec map[string]interface{}
+}
+
+// PassthroughYAML returns the container fields the chart does not model, such
+// as args or volumeMounts, as YAML.
+func (c Container) PassthroughYAML() (string, error) {
+	return passthroughYAML(c.Spec, modelledContainerFields)
 }
 
 type ResourceRequirements struct {
@@ -24,34 +169,217 @@ type ResourceRequirements struct {
 	Limits   ResourceList
 }
 
+func (r ResourceRequirements) IsZero() bool {
+	return r.Requests.IsZero() && r.Limits.IsZero()
+}
+
 type ResourceList struct {
 	CPU    string
 	Memory string
 }
 
+func (r ResourceList) IsZero() bool {
+	return r.CPU == "" && r.Memory == ""
+}
+
+// ProbeConfig holds a container's probes; a nil probe is not rendered.
 type ProbeConfig struct {
-	Liveness  ProbeSpec
-	Readiness ProbeSpec
-	Startup   ProbeSpec
+	Liveness  *ProbeSpec
+	Readiness *ProbeSpec
+	Startup   *ProbeSpec
+}
+
+// NamedProbe is a probe together with its container field name and the key
+// of its probes.<ValuesKey> entry in values.
+type NamedProbe struct {
+	Field     string
+	ValuesKey string
+	Probe     *ProbeSpec
+}
+
+// List returns the probes that are set, in rendering order.
+func (c ProbeConfig) List() []NamedProbe {
+	all := []NamedProbe{
+		{"livenessProbe", "liveness", c.Liveness},
+		{"readinessProbe", "readiness", c.Readiness},
+		{"startupProbe", "startup", c.Startup},
+	}
+	var out []NamedProbe
+	for _, p := range all {
+		if p.Probe != nil {
+			out = append(out, p)
+		}
+	}
+	return out
 }
 
+// ProbeSpec mirrors the Kubernetes Probe. Exactly one handler is set. Timing
+// fields left at zero are omitted so the Kubernetes defaults apply.
 type ProbeSpec struct {
-	Path                string
-	FailureThreshold    int
-	InitialDelaySeconds int
-	PeriodSeconds       int
-	SuccessThreshold    int
-	TimeoutSeconds      int
+	HTTPGet   *HTTPGetAction
+	TCPSocket *TCPSocketAction
+	Exec      *ExecAction
+	GRPC      *GRPCAction
+
+	FailureThreshold              int
+	InitialDelaySeconds           int
+	PeriodSeconds                 int
+	SuccessThreshold              int
+	TimeoutSeconds                int
+	TerminationGracePeriodSeconds *int
+}
+
+// HTTPGetAction probes an HTTP endpoint. Port is either a port number or a
+// port name; a number matching the container port is rewritten to its name.
+type HTTPGetAction struct {
+	Path        string
+	Port        string
+	Host        string
+	Scheme      string
+	HTTPHeaders []HTTPHeader
 }
 
-type StaticEnvVar struct {
+type HTTPHeader struct {
 	Name  string
 	Value string
 }
 
+type TCPSocketAction struct {
+	Port string
+	Host string
+}
+
+type ExecAction struct {
+	Command []string
+}
+
+type GRPCAction struct {
+	Port    int
+	Service *string
+}
+
+// EnvVar is a container env entry, rendered in upstream order. Entries whose
+// value is an image reference are rendered from the ImageValues values key
+// instead of Value, so that the image can be overridden.
+type EnvVar struct {
+	Name        string
+	Value       string
+	ValueFrom   *EnvVarSource
+	ImageValues string
+}
+
+// EnvVarSource mirrors the Kubernetes EnvVarSource; exactly one field is set.
+// A FieldRef is a downward API reference to the pod's own metadata or status.
+type EnvVarSource struct {
+	FieldRef         *ObjectFieldSelector
+	ResourceFieldRef *ResourceFieldSelector
+	ConfigMapKeyRef  *KeySelector
+	SecretKeyRef     *KeySelector
+}
+
+type ObjectFieldSelector struct {
+	APIVersion string
+	FieldPath  string
+}
+
+type ResourceFieldSelector struct {
+	ContainerName string
+	Resource      string
+	Divisor       string
+}
+
+// KeySelector and SourceRef refer to a ConfigMap or Secret. Where upstream
+// ships it, RenderedName is the name the chart renders it with.
+type KeySelector struct {
+	Name         string
+	RenderedName string
+	Key          string
+	Optional     *bool
+}
+
+// EnvFromSource mirrors the Kubernetes EnvFromSource; exactly one of
+// ConfigMapRef or SecretRef is set.
+type EnvFromSource struct {
+	Prefix       string
+	ConfigMapRef *SourceRef
+	SecretRef    *SourceRef
+}
+
+type SourceRef struct {
+	Name         string
+	RenderedName string
+	Optional     *bool
+}
+
+// ContainerPort is a port of a container. The first port of the operator
+// container is its main port: it is always named (http if upstream leaves it
+// unnamed) and its number comes from the containerPort value.
+type ContainerPort struct {
+	Name          string
+	ContainerPort int
+	Protocol      string
+	HostPort      int
+	HostIP        string
+}
+
+// PortName returns the name of the container port numbered n, or "" if no
+// named port matches.
+func (c Container) PortName(n int) string {
+	for _, p := range c.Ports {
+		if p.ContainerPort == n {
+			return p.Name
+		}
+	}
+	return ""
+}
+
 type ServiceData struct {
-	Type string
-	Port int
+	Type  string
+	Ports []ServicePort
+}
+
+// ServicePort is a port of the upstream Service. The first one reads its port
+// number from service.port. TargetPort is a port name where upstream targets
+// a numbered operator container port, so it follows containerPort.
+type ServicePort struct {
+	Name        string
+	Port        int
+	TargetPort  string
+	Protocol    string
+	NodePort    int
+	AppProtocol string
+}
+
+// Resource is an upstream resource the chart has no dedicated template for.
+// It is rendered verbatim under templates/upstream/, with the chart's name and
+// labels, and can be turned off with upstream.<ValuesKey>.enabled.
+type Resource struct {
+	APIVersion      string
+	Kind            string
+	OriginalName    string
+	Suffix          string
+	ValuesKey       string
+	ClusterScoped   bool
+	AnnotationsYAML string
+	BodyYAML        string
+}
+
+// RenderedName returns the Helm expression of the name the resource is
+// rendered with. References to it from other templates use the same one.
+func (r Resource) RenderedName() string {
+	if r.Suffix == "" {
+		return `{{ include "keycloak-operator.fullname" . }}`
+	}
+	return `{{ include "keycloak-operator.fullname" . }}-` + r.Suffix
+}
+
+// FileName returns the template path of the resource, relative to templates/.
+func (r Resource) FileName() string {
+	name := strings.ToLower(r.Kind)
+	if r.Suffix != "" {
+		name += "-" + r.Suffix
+	}
+	return "upstream/" + name + ".yaml"
 }
 
 type RBACData struct {
@@ -65,6 +393,7 @@ type RBACRole struct {
 	OriginalName string
 	Suffix       string
 	RulesYAML    string
+	Rules        []interface{}
 }
 
 type RBACBinding struct {
@@ -0,0 +1,55 @@
+package helmrepo
+
+import (
+	"fmt"
+	"os"
+)
+
+// ArtifactHubRepoFile is the repository metadata Artifact Hub reads from the
+// root of a chart repository.
+const ArtifactHubRepoFile = "artifacthub-repo.yml"
+
+// ArtifactHubRepo is the content of ArtifactHubRepoFile.
+type ArtifactHubRepo struct {
+	// RepositoryID is the ID Artifact Hub assigned the repository. It
+	// proves ownership for the Verified Publisher label; empty omits it.
+	RepositoryID string  `yaml:"repositoryID,omitempty"`
+	Owners       []Owner `yaml:"owners,omitempty"`
+}
+
+// Owner is someone who may claim the repository on Artifact Hub.
+type Owner struct {
+	Name  string `yaml:"name,omitempty"`
+	Email string `yaml:"email"`
+}
+
+// ArtifactHubRepo returns the repository metadata for the named chart. The
+// owners are the maintainers of its newest version that have an email.
+func (idx *Index) ArtifactHubRepo(name, repositoryID string) ArtifactHubRepo {
+	idx.sort()
+	repo := ArtifactHubRepo{RepositoryID: repositoryID}
+	entries := idx.Entries[name]
+	if len(entries) == 0 {
+		return repo
+	}
+	maintainers, _ := entries[0]["maintainers"].([]interface{})
+	for _, m := range maintainers {
+		mm, _ := m.(map[string]interface{})
+		email, _ := mm["email"].(string)
+		if email == "" {
+			continue
+		}
+		owner, _ := mm["name"].(string)
+		repo.Owners = append(repo.Owners, Owner{Name: owner, Email: email})
+	}
+	return repo
+}
+
+// Write writes the metadata to path.
+func (r ArtifactHubRepo) Write(path string) error {
+	data, err := encodeYAML(r)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", ArtifactHubRepoFile, err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
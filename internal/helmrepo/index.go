@@ -0,0 +1,195 @@
+package helmrepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/px3-dev/keycloak-operator/internal/semver"
+)
+
+// ErrNotFound is returned by LoadIndex when there is no index at the location.
+var ErrNotFound = errors.New("index not found")
+
+// Index is a Helm repository index.yaml. Entries are kept as generic maps so
+// that fields this package does not know survive a merge.
+type Index struct {
+	APIVersion string                              `yaml:"apiVersion"`
+	Entries    map[string][]map[string]interface{} `yaml:"entries"`
+	Generated  string                              `yaml:"generated"`
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{APIVersion: "v1", Entries: map[string][]map[string]interface{}{}}
+}
+
+// LoadIndex reads an index from a file or an http(s) URL. It returns
+// ErrNotFound if the file does not exist or the server answers 404.
+func LoadIndex(location string) (*Index, error) {
+	data, err := readLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	idx := NewIndex()
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string][]map[string]interface{}{}
+	}
+	return idx, nil
+}
+
+// httpClient fetches remote indexes. Its timeout keeps an unresponsive
+// repository from hanging the command.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+func readLocation(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := os.ReadFile(location)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", location, ErrNotFound)
+		}
+		return data, err
+	}
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", location, ErrNotFound)
+	}
+	return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+}
+
+// Add indexes a packaged chart, available at baseURL/<file name>, with the
+// given created time. An existing entry for the same chart version is
+// replaced.
+func (idx *Index) Add(tgzPath, baseURL string, created time.Time) error {
+	data, err := os.ReadFile(tgzPath)
+	if err != nil {
+		return err
+	}
+	meta, err := chartMetadata(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", tgzPath, err)
+	}
+	sum := sha256.Sum256(data)
+
+	entry := make(map[string]interface{}, len(meta.Raw)+3)
+	for k, v := range meta.Raw {
+		entry[k] = v
+	}
+	entry["urls"] = []string{strings.TrimSuffix(baseURL, "/") + "/" + filepath.Base(tgzPath)}
+	entry["created"] = created.UTC().Format(time.RFC3339Nano)
+	entry["digest"] = hex.EncodeToString(sum[:])
+
+	versions := idx.Entries[meta.Name]
+	for i, v := range versions {
+		if v["version"] == meta.Version {
+			versions = append(versions[:i], versions[i+1:]...)
+			break
+		}
+	}
+	idx.Entries[meta.Name] = append(versions, entry)
+	return nil
+}
+
+// Lookup returns the entry of the named chart whose field key has value.
+func (idx *Index) Lookup(name, key, value string) (map[string]interface{}, bool) {
+	for _, v := range idx.Entries[name] {
+		if fmt.Sprint(v[key]) == value {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Merge adds every entry of other whose chart version idx does not have.
+func (idx *Index) Merge(other *Index) {
+	for name, versions := range other.Entries {
+		have := make(map[interface{}]bool)
+		for _, v := range idx.Entries[name] {
+			have[v["version"]] = true
+		}
+		for _, v := range versions {
+			if !have[v["version"]] {
+				idx.Entries[name] = append(idx.Entries[name], v)
+			}
+		}
+	}
+}
+
+// Write sorts every chart's entries newest version first, as helm does, and
+// writes the index.
+func (idx *Index) Write(file string, generated time.Time) error {
+	idx.sort()
+	idx.Generated = generated.UTC().Format(time.RFC3339Nano)
+
+	data, err := encodeYAML(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o644)
+}
+
+func (idx *Index) sort() {
+	for _, versions := range idx.Entries {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return compareEntryVersions(versions[i], versions[j]) > 0
+		})
+	}
+}
+
+func compareEntryVersions(a, b map[string]interface{}) int {
+	av, aErr := semver.Parse(fmt.Sprint(a["version"]))
+	bv, bErr := semver.Parse(fmt.Sprint(b["version"]))
+	if aErr != nil || bErr != nil {
+		return strings.Compare(fmt.Sprint(a["version"]), fmt.Sprint(b["version"]))
+	}
+	return av.Compare(bv)
+}
+
+// chartMetadata reads <name>/Chart.yaml from a packaged chart.
+func chartMetadata(tgz []byte) (Metadata, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return Metadata{}, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return Metadata{}, fmt.Errorf("no Chart.yaml")
+		}
+		if err != nil {
+			return Metadata{}, err
+		}
+		if dir, file := path.Split(hdr.Name); file == "Chart.yaml" && strings.Count(dir, "/") == 1 {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return Metadata{}, err
+			}
+			return parseMetadata(data)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package helmrepo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func packageChart(t *testing.T, version, dest string) string {
+	t.Helper()
+	tgz, err := Package(writeChart(t, version), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tgz
+}
+
+func versions(idx *Index) []string {
+	var out []string
+	for _, e := range idx.Entries["keycloak-operator"] {
+		out = append(out, e["version"].(string))
+	}
+	return out
+}
+
+// entry returns the keycloak-operator entry of the given version.
+func entry(idx *Index, version string) map[string]interface{} {
+	for _, e := range idx.Entries["keycloak-operator"] {
+		if e["version"] == version {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestIndexAddMergeWrite(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	old := NewIndex()
+	if err := old.Add(packageChart(t, "0.5.0", dir), "https://charts.example/", created); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Add(packageChart(t, "0.6.0", dir), "https://charts.example/", created); err != nil {
+		t.Fatal(err)
+	}
+	// Fields this package does not know survive a merge.
+	old.Entries["keycloak-operator"][0]["deprecated"] = true
+
+	idx := NewIndex()
+	if err := idx.Add(packageChart(t, "0.6.0", dir), "https://charts.example", created.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Add(packageChart(t, "0.10.0", dir), "https://charts.example", created); err != nil {
+		t.Fatal(err)
+	}
+	idx.Merge(old)
+
+	out := filepath.Join(dir, "index.yaml")
+	if err := idx.Write(out, created); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadIndex(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"0.10.0", "0.6.0", "0.5.0"}; !equal(versions(got), want) {
+		t.Errorf("versions = %v, want %v, newest first", versions(got), want)
+	}
+	e060 := entry(got, "0.6.0")
+	if e060["created"] != created.Add(time.Hour).Format(time.RFC3339Nano) {
+		t.Errorf("0.6.0 created = %v; the merged index must not replace a version it has", e060["created"])
+	}
+	e050 := entry(got, "0.5.0")
+	if e050["deprecated"] != true {
+		t.Errorf("0.5.0 lost its deprecated field: %v", e050)
+	}
+	urls, _ := e050["urls"].([]interface{})
+	if len(urls) != 1 || urls[0] != "https://charts.example/keycloak-operator-0.5.0.tgz" {
+		t.Errorf("0.5.0 urls = %v", urls)
+	}
+	if digest, _ := e060["digest"].(string); len(digest) != 64 {
+		t.Errorf("0.6.0 digest = %q", digest)
+	}
+	if got.Generated != created.Format(time.RFC3339Nano) {
+		t.Errorf("generated = %s", got.Generated)
+	}
+
+	// Writing the same index again gives the same bytes.
+	first, _ := os.ReadFile(out)
+	if err := got.Write(out, created); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(out)
+	if string(first) != string(second) {
+		t.Error("rewriting the index changed it")
+	}
+}
+
+func TestLoadIndexHTTP(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex()
+	if err := idx.Add(packageChart(t, "0.6.0", dir), "https://charts.example", time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Write(filepath.Join(dir, "index.yaml"), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	got, err := LoadIndex(srv.URL + "/index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0.6.0"}; !equal(versions(got), want) {
+		t.Errorf("versions = %v, want %v", versions(got), want)
+	}
+
+	if _, err := LoadIndex(srv.URL + "/missing/index.yaml"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadIndex of a 404 = %v, want ErrNotFound", err)
+	}
+	if _, err := LoadIndex(filepath.Join(dir, "missing.yaml")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadIndex of a missing file = %v, want ErrNotFound", err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIndexLookup(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex()
+	if err := idx.Add(packageChart(t, "0.6.0", dir), "https://charts.example", time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, value string
+		found      bool
+	}{
+		{"version", "0.6.0", true},
+		{"version", "0.5.0", false},
+		{"appVersion", "26.5.3", true},
+		{"appVersion", "26.5.2", false},
+	}
+	for _, tt := range tests {
+		e, ok := idx.Lookup("keycloak-operator", tt.key, tt.value)
+		if ok != tt.found {
+			t.Errorf("Lookup %s=%s found = %v, want %v", tt.key, tt.value, ok, tt.found)
+		}
+		if ok && e["version"] != "0.6.0" {
+			t.Errorf("Lookup %s=%s = %v", tt.key, tt.value, e)
+		}
+	}
+	if _, ok := idx.Lookup("other", "version", "0.6.0"); ok {
+		t.Error("Lookup found a version of another chart")
+	}
+}
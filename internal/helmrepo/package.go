@@ -0,0 +1,194 @@
+// Package helmrepo packages charts and maintains a Helm repository index, in
+// the formats helm package and helm repo index produce.
+package helmrepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata is the part of Chart.yaml the packaging needs. The whole file is
+// kept in Raw for the index entry.
+type Metadata struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	AppVersion string `yaml:"appVersion"`
+
+	Raw map[string]interface{} `yaml:"-"`
+}
+
+// LoadMetadata reads Chart.yaml from a chart directory.
+func LoadMetadata(chartDir string) (Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return Metadata{}, err
+	}
+	return parseMetadata(data)
+}
+
+func parseMetadata(data []byte) (Metadata, error) {
+	var m Metadata
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return Metadata{}, fmt.Errorf("Chart.yaml: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &m.Raw); err != nil {
+		return Metadata{}, fmt.Errorf("Chart.yaml: %w", err)
+	}
+	if m.Name == "" || m.Version == "" {
+		return Metadata{}, fmt.Errorf("Chart.yaml: name and version are required")
+	}
+	return m, nil
+}
+
+// Package writes the chart in chartDir to destDir as <name>-<version>.tgz
+// and returns its path. Entries are sorted and every header carries the same
+// mtime, owner and mode, so packaging the same files gives the same bytes.
+// Files matched by the chart's .helmignore are left out.
+func Package(chartDir, destDir string) (string, error) {
+	meta, err := LoadMetadata(chartDir)
+	if err != nil {
+		return "", err
+	}
+	ignore, err := loadHelmignore(filepath.Join(chartDir, ".helmignore"))
+	if err != nil {
+		return "", err
+	}
+
+	var files []string
+	err = filepath.WalkDir(chartDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(chartDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.matches(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, rel := range files {
+		data, err := os.ReadFile(filepath.Join(chartDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return "", err
+		}
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(meta.Name, rel),
+			Mode:     0o644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(destDir, fmt.Sprintf("%s-%s.tgz", meta.Name, meta.Version))
+	if err := os.WriteFile(dst, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// helmignore holds .helmignore rules: shell globs matched against the path
+// and the base name, a trailing '/' for directories only and a leading '!'
+// to re-include.
+type helmignore []ignoreRule
+
+type ignoreRule struct {
+	pattern string
+	dirOnly bool
+	negate  bool
+}
+
+func loadHelmignore(file string) (helmignore, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules helmignore
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		r.pattern = strings.TrimPrefix(line, "/")
+		if _, err := path.Match(r.pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %q: %w", file, line, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// matches reports whether rel is ignored. As in helm, the last matching rule
+// wins.
+func (h helmignore) matches(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range h {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		full, _ := path.Match(r.pattern, rel)
+		base, _ := path.Match(r.pattern, path.Base(rel))
+		if full || base {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,126 @@
+package helmrepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeChart writes a chart of the given version to a new directory.
+func writeChart(t *testing.T, version string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":             "apiVersion: v2\nname: keycloak-operator\nversion: " + version + "\nappVersion: \"26.5.3\"\n",
+		"values.yaml":            "replicas: 1\n",
+		".helmignore":            "*.orig\nscratch/\n",
+		"templates/service.yaml": "kind: Service\n",
+		"templates/NOTES.txt":    "installed\n",
+		"crds/keycloaks.yml":     "kind: CustomResourceDefinition\n",
+		"values.yaml.orig":       "ignored\n",
+		"scratch/notes.md":       "ignored\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func tarEntries(t *testing.T, tgz []byte) []string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(time.Unix(0, 0)) || hdr.Mode != 0o644 {
+			t.Errorf("%s: mtime %v, mode %o", hdr.Name, hdr.ModTime, hdr.Mode)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestPackageReproducible(t *testing.T) {
+	chartDir := writeChart(t, "0.6.0")
+	first, err := Package(chartDir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(first) != "keycloak-operator-0.6.0.tgz" {
+		t.Errorf("Package wrote %s", first)
+	}
+
+	// Touching the files changes nothing in the archive.
+	later := time.Now().Add(time.Hour)
+	filepath.Walk(chartDir, func(p string, _ os.FileInfo, _ error) error {
+		return os.Chtimes(p, later, later)
+	})
+	second, err := Package(chartDir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := os.ReadFile(first)
+	b, _ := os.ReadFile(second)
+	if !bytes.Equal(a, b) {
+		t.Error("packaging the same chart twice gave different archives")
+	}
+
+	want := []string{
+		"keycloak-operator/.helmignore",
+		"keycloak-operator/Chart.yaml",
+		"keycloak-operator/crds/keycloaks.yml",
+		"keycloak-operator/templates/NOTES.txt",
+		"keycloak-operator/templates/service.yaml",
+		"keycloak-operator/values.yaml",
+	}
+	if got := tarEntries(t, a); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestHelmignore(t *testing.T) {
+	rules := helmignore{
+		{pattern: "*.tgz"},
+		{pattern: "charts", dirOnly: true},
+		{pattern: "keep.tgz", negate: true},
+	}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"old.tgz", false, true},
+		{"sub/old.tgz", false, true},
+		{"keep.tgz", false, false},
+		{"charts", true, true},
+		{"charts", false, false},
+		{"values.yaml", false, false},
+	}
+	for _, tt := range tests {
+		if got := rules.matches(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("matches(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package helmrepo
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/px3-dev/keycloak-operator/internal/semver"
+)
+
+// CRDsAnnotation is the Chart.yaml annotation in which the generator records
+// the CRDs a chart ships: name, served versions and file digest.
+const CRDsAnnotation = "px3-dev.github.io/crds"
+
+// Page is what the repository landing page renders from.
+type Page struct {
+	Name     string
+	RepoURL  string
+	OCIRepo  string // oci://<registry>/<namespace>, or empty if not published
+	Releases []Release
+}
+
+// Release is one chart version of the index, as the landing page shows it.
+type Release struct {
+	Version    string
+	AppVersion string
+	Date       string // release date, YYYY-MM-DD
+	Digest     string // sha256:<hex> of the archive
+	// CRDChanges lists how the CRDs differ from the previous release, if
+	// CRDsKnown. Empty means no change.
+	CRDChanges []string
+	CRDsKnown  bool
+}
+
+// ShortDigest is the digest cut to 12 hex digits.
+func (r Release) ShortDigest() string {
+	if len(r.Digest) > len("sha256:")+12 {
+		return r.Digest[:len("sha256:")+12]
+	}
+	return r.Digest
+}
+
+// Latest is the newest release that is not a pre-release, or the newest one.
+func (p Page) Latest() *Release {
+	for i, r := range p.Releases {
+		if v, err := semver.Parse(r.Version); err == nil && v.Pre == "" {
+			return &p.Releases[i]
+		}
+	}
+	if len(p.Releases) > 0 {
+		return &p.Releases[0]
+	}
+	return nil
+}
+
+type crdRecord struct {
+	Name     string   `yaml:"name"`
+	Versions []string `yaml:"versions"`
+	Digest   string   `yaml:"digest"`
+}
+
+// Page returns the landing page data for the named chart, newest release
+// first. The CRDs of a release whose CRDs are split out come from the
+// <name>-crds chart of the same version.
+func (idx *Index) Page(name, repoURL, ociRepo string) Page {
+	idx.sort()
+	p := Page{Name: name, RepoURL: strings.TrimSuffix(repoURL, "/"), OCIRepo: strings.TrimSuffix(ociRepo, "/")}
+	entries := idx.Entries[name]
+	entryCRDs := func(e map[string]interface{}) ([]crdRecord, bool) {
+		if crds, ok := entryCRDs(e); ok {
+			return crds, true
+		}
+		if split, ok := idx.Lookup(name+"-crds", "version", fmt.Sprint(e["version"])); ok {
+			return entryCRDs(split)
+		}
+		return nil, false
+	}
+	for i, e := range entries {
+		r := Release{
+			Version:    fmt.Sprint(e["version"]),
+			AppVersion: fmt.Sprint(e["appVersion"]),
+		}
+		if digest, ok := e["digest"].(string); ok {
+			r.Digest = "sha256:" + digest
+		}
+		if created, ok := e["created"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, created); err == nil {
+				r.Date = t.UTC().Format("2006-01-02")
+			}
+		}
+		cur, ok := entryCRDs(e)
+		if i+1 < len(entries) {
+			prev, prevOK := entryCRDs(entries[i+1])
+			if ok && prevOK {
+				r.CRDChanges, r.CRDsKnown = crdChanges(prev, cur), true
+			}
+		} else if ok {
+			// The oldest release introduces its CRDs.
+			r.CRDChanges, r.CRDsKnown = crdChanges(nil, cur), true
+		}
+		p.Releases = append(p.Releases, r)
+	}
+	return p
+}
+
+// entryCRDs reads the CRDs annotation of an index entry. It reports false
+// for charts generated before the annotation existed.
+func entryCRDs(e map[string]interface{}) ([]crdRecord, bool) {
+	annotations, _ := e["annotations"].(map[string]interface{})
+	s, ok := annotations[CRDsAnnotation].(string)
+	if !ok {
+		return nil, false
+	}
+	var crds []crdRecord
+	if err := yaml.Unmarshal([]byte(s), &crds); err != nil {
+		return nil, false
+	}
+	return crds, true
+}
+
+func crdChanges(prev, cur []crdRecord) []string {
+	old := make(map[string]crdRecord, len(prev))
+	for _, c := range prev {
+		old[c.Name] = c
+	}
+	var changes []string
+	for _, c := range cur {
+		o, ok := old[c.Name]
+		delete(old, c.Name)
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("added %s (%s)", c.Name, strings.Join(c.Versions, ", ")))
+		case strings.Join(o.Versions, ",") != strings.Join(c.Versions, ","):
+			changes = append(changes, fmt.Sprintf("%s: versions %s → %s", c.Name, strings.Join(o.Versions, ", "), strings.Join(c.Versions, ", ")))
+		case o.Digest != c.Digest:
+			changes = append(changes, fmt.Sprintf("%s: schema changed", c.Name))
+		}
+	}
+	var removed []string
+	for name := range old {
+		removed = append(removed, "removed "+name)
+	}
+	sort.Strings(removed)
+	return append(changes, removed...)
+}
+
+// RenderPage renders the html/template in tmplFile with p and writes it to
+// outFile.
+func RenderPage(tmplFile, outFile string, p Page) error {
+	tmpl, err := template.New(filepath.Base(tmplFile)).ParseFiles(tmplFile)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return fmt.Errorf("%s: %w", tmplFile, err)
+	}
+	return os.WriteFile(outFile, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,42 @@
+package helmrepo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPageSplitCRDs(t *testing.T) {
+	crds := func(digest string) map[string]interface{} {
+		return map[string]interface{}{
+			CRDsAnnotation: "- name: keycloaks.k8s.keycloak.org\n  versions: [v2alpha1]\n  digest: sha256:" + digest + "\n",
+		}
+	}
+	idx := NewIndex()
+	idx.Entries["keycloak-operator"] = []map[string]interface{}{
+		{"version": "0.5.0", "annotations": crds("aa")},
+		// From 0.6.0 on, the CRDs are in the CRD chart.
+		{"version": "0.6.0", "annotations": map[string]interface{}{}},
+		{"version": "0.7.0", "annotations": map[string]interface{}{}},
+	}
+	idx.Entries["keycloak-operator-crds"] = []map[string]interface{}{
+		{"version": "0.6.0", "annotations": crds("aa")},
+		{"version": "0.7.0", "annotations": crds("bb")},
+	}
+
+	p := idx.Page("keycloak-operator", "https://charts.example/", "")
+	var got [][]string
+	for _, r := range p.Releases {
+		if !r.CRDsKnown {
+			t.Errorf("%s: CRDs unknown", r.Version)
+		}
+		got = append(got, append([]string{}, r.CRDChanges...))
+	}
+	want := [][]string{
+		{"keycloaks.k8s.keycloak.org: schema changed"},
+		{},
+		{"added keycloaks.k8s.keycloak.org (v2alpha1)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CRD changes = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+package helmrepo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"gopkg.in/yaml.v3"
+)
+
+// ProvMediaType is the OCI media type of a chart's provenance file.
+const ProvMediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+
+// pgpConfig matches the hash helm signs with.
+var pgpConfig = packet.Config{DefaultHash: crypto.SHA512}
+
+// sumCollection is the second document of a provenance file.
+type sumCollection struct {
+	Files map[string]string `yaml:"files"`
+}
+
+// ReadKeyRing reads OpenPGP keys, armored or binary.
+func ReadKeyRing(data []byte) (openpgp.EntityList, error) {
+	if keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err == nil {
+		return keys, nil
+	}
+	keys, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading OpenPGP keys: %w", err)
+	}
+	return keys, nil
+}
+
+// SigningKey returns the signing key of the first entity in keys that has a
+// private one, decrypted with passphrase if it is protected.
+func SigningKey(keys openpgp.EntityList, passphrase []byte) (*packet.PrivateKey, error) {
+	for _, e := range keys {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			if len(passphrase) == 0 {
+				return nil, fmt.Errorf("key %X is protected by a passphrase", e.PrimaryKey.Fingerprint)
+			}
+			if err := e.DecryptPrivateKeys(passphrase); err != nil {
+				return nil, fmt.Errorf("key %X: %w", e.PrimaryKey.Fingerprint, err)
+			}
+		}
+		key, ok := e.SigningKey(time.Now())
+		if !ok || key.PrivateKey == nil {
+			return nil, fmt.Errorf("key %X cannot sign", e.PrimaryKey.Fingerprint)
+		}
+		return key.PrivateKey, nil
+	}
+	return nil, fmt.Errorf("no private key")
+}
+
+// Sign writes the provenance file of a packaged chart next to it, as helm
+// package --sign does, and returns its path: the chart's Chart.yaml and the
+// SHA-256 of the archive, clearsigned with key.
+func Sign(tgzPath string, key *packet.PrivateKey) (string, error) {
+	data, err := os.ReadFile(tgzPath)
+	if err != nil {
+		return "", err
+	}
+	meta, err := chartMetadata(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", tgzPath, err)
+	}
+	block, err := messageBlock(meta, filepath.Base(tgzPath), data)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, key, &pgpConfig)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(block); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	prov := tgzPath + ".prov"
+	if err := os.WriteFile(prov, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	return prov, nil
+}
+
+// messageBlock returns the signed part of a provenance file. The two YAML
+// documents are separated by a document end marker, because the "---" start
+// marker is not allowed in a clearsigned message.
+func messageBlock(meta Metadata, name string, tgz []byte) ([]byte, error) {
+	chart, err := encodeYAML(meta.Raw)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := encodeYAML(sumCollection{Files: map[string]string{name: fmt.Sprintf("sha256:%x", sha256.Sum256(tgz))}})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{chart, sums}, []byte("\n...\n")), nil
+}
+
+func encodeYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Verification is the result of a successful Verify.
+type Verification struct {
+	Signer *openpgp.Entity
+	// FileHash is the archive digest the provenance file vouches for.
+	FileHash string
+}
+
+// Verify checks a packaged chart against its provenance file: the signature
+// must come from a key in keyring, and the recorded digest, name and version
+// must match the archive. It needs no network access.
+func Verify(tgzPath, provPath string, keyring openpgp.KeyRing) (*Verification, error) {
+	tgz, err := os.ReadFile(tgzPath)
+	if err != nil {
+		return nil, err
+	}
+	prov, err := os.ReadFile(provPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no clearsigned message", provPath)
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, &pgpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provPath, err)
+	}
+
+	metaDoc, sumsDoc, ok := strings.Cut(string(block.Plaintext), "\n...\n")
+	if !ok {
+		return nil, fmt.Errorf("%s: no file digests", provPath)
+	}
+	signed, err := parseMetadata([]byte(metaDoc))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provPath, err)
+	}
+	var sums sumCollection
+	if err := yaml.Unmarshal([]byte(sumsDoc), &sums); err != nil {
+		return nil, fmt.Errorf("%s: %w", provPath, err)
+	}
+
+	name := filepath.Base(tgzPath)
+	want, got := sums.Files[name], fmt.Sprintf("sha256:%x", sha256.Sum256(tgz))
+	if want == "" {
+		return nil, fmt.Errorf("%s: no digest for %s", provPath, name)
+	}
+	if want != got {
+		return nil, fmt.Errorf("%s: digest %s does not match the signed %s", name, got, want)
+	}
+	meta, err := chartMetadata(tgz)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tgzPath, err)
+	}
+	if meta.Name != signed.Name || meta.Version != signed.Version {
+		return nil, fmt.Errorf("%s: chart %s-%s does not match the signed %s-%s", name, meta.Name, meta.Version, signed.Name, signed.Version)
+	}
+	return &Verification{Signer: signer, FileHash: got}, nil
+}
@@ -0,0 +1,113 @@
+package helmrepo
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// newKeyRing returns a new signing entity and its public key ring, read back
+// from armor as a user would pass it.
+func newKeyRing(t *testing.T, name string) (*openpgp.Entity, openpgp.EntityList) {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	keys, err := ReadKeyRing(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, keys
+}
+
+func TestSignVerify(t *testing.T) {
+	signer, keyring := newKeyRing(t, "release")
+	key, err := SigningKey(openpgp.EntityList{signer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgz := packageChart(t, "0.6.0", t.TempDir())
+	prov, err := Sign(tgz, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov != tgz+".prov" {
+		t.Errorf("Sign wrote %s", prov)
+	}
+
+	v, err := Verify(tgz, prov, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Signer.PrimaryKey.KeyId != signer.PrimaryKey.KeyId {
+		t.Errorf("signed by %X, want %X", v.Signer.PrimaryKey.KeyId, signer.PrimaryKey.KeyId)
+	}
+	if !strings.HasPrefix(v.FileHash, "sha256:") {
+		t.Errorf("FileHash = %s", v.FileHash)
+	}
+
+	_, other := newKeyRing(t, "other")
+	if _, err := Verify(tgz, prov, other); err == nil {
+		t.Error("Verify accepted a signature from a key outside the keyring")
+	}
+
+	// An archive that differs from the signed one is rejected.
+	if err := os.WriteFile(tgz, append(readFile(t, tgz), 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(tgz, prov, keyring); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Verify of a modified archive = %v, want a digest mismatch", err)
+	}
+}
+
+func TestVerifyRejectsOtherChart(t *testing.T) {
+	signer, keyring := newKeyRing(t, "release")
+	key, err := SigningKey(openpgp.EntityList{signer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	prov, err := Sign(packageChart(t, "0.5.0", dir), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(packageChart(t, "0.6.0", dir), prov, keyring); err == nil {
+		t.Error("Verify accepted the provenance file of another chart version")
+	}
+}
+
+func TestSigningKeyPassphrase(t *testing.T) {
+	e, _ := newKeyRing(t, "release")
+	if err := e.EncryptPrivateKeys([]byte("passphrase"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SigningKey(openpgp.EntityList{e}, nil); err == nil {
+		t.Error("SigningKey decrypted a protected key without a passphrase")
+	}
+	if _, err := SigningKey(openpgp.EntityList{e}, []byte("passphrase")); err != nil {
+		t.Errorf("SigningKey with the passphrase: %v", err)
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
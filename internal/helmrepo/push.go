@@ -0,0 +1,145 @@
+package helmrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/px3-dev/keycloak-operator/internal/oci"
+)
+
+// Helm's OCI media types.
+const (
+	ConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+	ChartMediaType  = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// Push uploads a packaged chart to an OCI registry as helm push does: to
+// <target>/<chart name>, tagged with the chart version. target is
+// oci://<registry>/<namespace>. Push returns the pushed reference and
+// manifest digest.
+func Push(ctx context.Context, client *oci.Client, tgzPath, target string, created time.Time) (string, string, error) {
+	registry, namespace, err := parseTarget(target)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := os.ReadFile(tgzPath)
+	if err != nil {
+		return "", "", err
+	}
+	meta, err := chartMetadata(data)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", tgzPath, err)
+	}
+	repository := strings.TrimPrefix(namespace+"/"+meta.Name, "/")
+	// OCI tags cannot contain '+', so helm replaces it in the version.
+	tag := strings.ReplaceAll(meta.Version, "+", "_")
+
+	config, err := json.Marshal(meta.Raw)
+	if err != nil {
+		return "", "", err
+	}
+	configDesc, err := client.PushBlob(ctx, registry, repository, ConfigMediaType, config)
+	if err != nil {
+		return "", "", fmt.Errorf("pushing config: %w", err)
+	}
+	chartDesc, err := client.PushBlob(ctx, registry, repository, ChartMediaType, data)
+	if err != nil {
+		return "", "", fmt.Errorf("pushing chart: %w", err)
+	}
+
+	layers := []oci.Descriptor{chartDesc}
+	// Like helm push, include the provenance file when it sits next to the
+	// archive.
+	prov, err := os.ReadFile(tgzPath + ".prov")
+	switch {
+	case err == nil:
+		provDesc, err := client.PushBlob(ctx, registry, repository, ProvMediaType, prov)
+		if err != nil {
+			return "", "", fmt.Errorf("pushing provenance: %w", err)
+		}
+		layers = append(layers, provDesc)
+	case !os.IsNotExist(err):
+		return "", "", err
+	}
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ImageManifestMediaType,
+		Config:        configDesc,
+		Layers:        layers,
+		Annotations:   manifestAnnotations(meta, created),
+	}
+	digest, err := client.PushManifest(ctx, registry, repository, tag, manifest)
+	if err != nil {
+		return "", "", fmt.Errorf("pushing manifest: %w", err)
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, repository, tag), digest, nil
+}
+
+func parseTarget(target string) (registry, namespace string, err error) {
+	rest, ok := strings.CutPrefix(target, "oci://")
+	if !ok {
+		return "", "", fmt.Errorf("target %q: must start with oci://", target)
+	}
+	registry, namespace, _ = strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if registry == "" {
+		return "", "", fmt.Errorf("target %q: no registry", target)
+	}
+	return registry, namespace, nil
+}
+
+// manifestAnnotations returns the OCI annotations helm push sets from
+// Chart.yaml: the standard org.opencontainers.image ones, then the chart's
+// own annotations, which may not override the title or version.
+func manifestAnnotations(meta Metadata, created time.Time) map[string]string {
+	str := func(key string) string {
+		s, _ := meta.Raw[key].(string)
+		return s
+	}
+	a := map[string]string{
+		"org.opencontainers.image.title":   meta.Name,
+		"org.opencontainers.image.version": meta.Version,
+		"org.opencontainers.image.created": created.UTC().Format(time.RFC3339),
+	}
+	if s := str("description"); s != "" {
+		a["org.opencontainers.image.description"] = s
+	}
+	if s := str("home"); s != "" {
+		a["org.opencontainers.image.url"] = s
+	}
+	if sources, _ := meta.Raw["sources"].([]interface{}); len(sources) > 0 {
+		if s, ok := sources[0].(string); ok {
+			a["org.opencontainers.image.source"] = s
+		}
+	}
+	maintainers, _ := meta.Raw["maintainers"].([]interface{})
+	var authors []string
+	for _, m := range maintainers {
+		mm, _ := m.(map[string]interface{})
+		name, _ := mm["name"].(string)
+		if email, _ := mm["email"].(string); email != "" {
+			name += " (" + email + ")"
+		}
+		if name != "" {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) > 0 {
+		a["org.opencontainers.image.authors"] = strings.Join(authors, ", ")
+	}
+
+	custom, _ := meta.Raw["annotations"].(map[string]interface{})
+	for k, v := range custom {
+		if k == "org.opencontainers.image.title" || k == "org.opencontainers.image.version" {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			a[k] = s
+		}
+	}
+	return a
+}
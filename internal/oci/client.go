@@ -0,0 +1,244 @@
+// Package oci is a minimal client for the OCI distribution API: enough to
+// resolve tags to digests and to push a Helm chart.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// manifestMediaTypes are the manifest types accepted when resolving a tag.
+// Index types come first so that a multi-arch image resolves to its index.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Client talks to OCI registries. The zero value uses HTTPS and anonymous
+// access.
+type Client struct {
+	HTTP *http.Client
+
+	// Endpoints maps a registry host, as it appears in image references, to
+	// the base URL to reach it at, e.g. quay.io=http://localhost:5000.
+	Endpoints map[string]string
+
+	// Username and Password are sent as basic auth, or used to obtain a
+	// bearer token when the registry asks for one. Token, when set, is sent
+	// as a bearer token as is.
+	Username string
+	Password string
+	Token    string
+
+	mu     sync.Mutex
+	tokens map[string]string // by realm and scope
+}
+
+// BaseURL returns the URL the client reaches registry at.
+func (c *Client) BaseURL(registry string) string {
+	if u, ok := c.Endpoints[registry]; ok {
+		return strings.TrimSuffix(u, "/")
+	}
+	if registry == "" || registry == "docker.io" {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + registry
+}
+
+// Resolve returns the digest of the manifest that reference (a tag or a
+// digest) points to in repository.
+func (c *Client) Resolve(ctx context.Context, registry, repository, reference string) (string, error) {
+	if registry == "" || registry == "docker.io" {
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.BaseURL(registry), repository, reference)
+	newRequest := func(method string) func() (*http.Request, error) {
+		return func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, method, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+			return req, nil
+		}
+	}
+	scope := "repository:" + repository + ":pull"
+
+	resp, err := c.Do(newRequest(http.MethodHead), scope)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			return digest, nil
+		}
+	} else if resp.StatusCode != http.StatusMethodNotAllowed {
+		return "", fmt.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+
+	// Not every registry returns the digest on HEAD; hash the manifest.
+	resp, err = c.Do(newRequest(http.MethodGet), scope)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", fmt.Errorf("GET %s: %w", url, err)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// Do sends the request built by newRequest, authenticating as the registry
+// asks. newRequest is called again for the retry, so that a request body can
+// be replayed. scope is the token scope to request, such as
+// repository:keycloak/keycloak:pull.
+func (c *Client) Do(newRequest func() (*http.Request, error), scope string) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, "")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || c.Token != "" {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "bearer"):
+		if params["scope"] == "" {
+			params["scope"] = scope
+		}
+		token, err := c.fetchToken(req.Context(), params)
+		if err != nil {
+			return nil, err
+		}
+		req, err = newRequest()
+		if err != nil {
+			return nil, err
+		}
+		c.authorize(req, token)
+	case strings.EqualFold(scheme, "basic") && c.Username != "":
+		// Basic credentials were already sent; the registry refused them.
+		return nil, fmt.Errorf("%s %s: credentials rejected", req.Method, req.URL)
+	default:
+		return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL)
+	}
+	return c.httpClient().Do(req)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) authorize(req *http.Request, token string) {
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// fetchToken obtains a bearer token from the realm of a challenge, sending
+// the basic credentials if there are any.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge without realm")
+	}
+	key := realm + " " + params["service"] + " " + params["scope"]
+	c.mu.Lock()
+	token, ok := c.tokens[key]
+	c.mu.Unlock()
+	if ok {
+		return token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm, nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token from %s: %s", realm, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token from %s: %w", realm, err)
+	}
+	token = body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("token from %s: empty response", realm)
+	}
+
+	c.mu.Lock()
+	if c.tokens == nil {
+		c.tokens = make(map[string]string)
+	}
+	c.tokens[key] = token
+	c.mu.Unlock()
+	return token, nil
+}
+
+var challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and
+// parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for _, m := range challengeParamRe.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	return scheme, params
+}
@@ -0,0 +1,219 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is a minimal OCI registry: it serves manifests and blobs from
+// memory, accepts monolithic blob uploads and manifest pushes, and requires a
+// bearer token from its /token endpoint when username is set.
+type fakeRegistry struct {
+	username, password string
+	headDigest         bool // send Docker-Content-Digest on manifest HEAD
+
+	mu        sync.Mutex
+	manifests map[string][]byte // by repository:reference
+	blobs     map[string][]byte // by repository@digest
+	uploads   int
+	scopes    []string // requested at /token
+}
+
+const testToken = "test-token"
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	r := &fakeRegistry{headDigest: true, manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		user, pass, _ := req.BasicAuth()
+		if user != r.username || pass != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+		fmt.Fprintf(w, `{"token": %q}`, testToken)
+		return
+	}
+	if r.username != "" && req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(w, req, repo, ref)
+	case strings.HasSuffix(path, "/blobs/uploads/") && req.Method == http.MethodPost:
+		repo := strings.TrimSuffix(path, "/blobs/uploads/")
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/session?state=secret")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasSuffix(path, "/blobs/uploads/session") && req.Method == http.MethodPut:
+		repo := strings.TrimSuffix(path, "/blobs/uploads/session")
+		data, _ := io.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if digest != fmt.Sprintf("sha256:%x", sha256.Sum256(data)) || req.URL.Query().Get("state") != "secret" {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		r.blobs[repo+"@"+digest] = data
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		repo, digest, _ := strings.Cut(path, "/blobs/")
+		if _, ok := r.blobs[repo+"@"+digest]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	if req.Method == http.MethodPut {
+		data, _ := io.ReadAll(req.Body)
+		r.manifests[repo+":"+ref] = data
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	data, ok := r.manifests[repo+":"+ref]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if req.Method == http.MethodHead {
+		if !r.headDigest {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(data)))
+		return
+	}
+	w.Write(data)
+}
+
+// client returns a Client that reaches srv as registry.example.
+func client(srv *httptest.Server) *Client {
+	return &Client{Endpoints: map[string]string{"registry.example": srv.URL}}
+}
+
+func TestResolve(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	want := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	for _, headDigest := range []bool{true, false} {
+		t.Run(fmt.Sprintf("headDigest=%v", headDigest), func(t *testing.T) {
+			reg, srv := newFakeRegistry(t)
+			reg.headDigest = headDigest
+			reg.manifests["keycloak/keycloak-operator:26.5.3"] = manifest
+
+			got, err := client(srv).Resolve(context.Background(), "registry.example", "keycloak/keycloak-operator", "26.5.3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("Resolve = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveUnknownTag(t *testing.T) {
+	_, srv := newFakeRegistry(t)
+	_, err := client(srv).Resolve(context.Background(), "registry.example", "keycloak/keycloak-operator", "0.0.0")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Resolve error = %v, want 404", err)
+	}
+}
+
+func TestResolveTokenAuth(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	reg.username, reg.password = "user", "secret"
+	reg.manifests["keycloak/keycloak-operator:26.5.3"] = []byte(`{}`)
+
+	c := client(srv)
+	c.Username, c.Password = "user", "secret"
+	for i := 0; i < 2; i++ {
+		if _, err := c.Resolve(context.Background(), "registry.example", "keycloak/keycloak-operator", "26.5.3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The token is fetched once for the scope and then reused.
+	if want := []string{"repository:keycloak/keycloak-operator:pull"}; fmt.Sprint(reg.scopes) != fmt.Sprint(want) {
+		t.Errorf("token scopes = %v, want %v", reg.scopes, want)
+	}
+
+	c = client(srv)
+	c.Username, c.Password = "user", "wrong"
+	if _, err := c.Resolve(context.Background(), "registry.example", "keycloak/keycloak-operator", "26.5.3"); err == nil {
+		t.Error("Resolve with wrong credentials succeeded")
+	}
+}
+
+func TestResolveStaticToken(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	reg.username = "user"
+	reg.manifests["keycloak/keycloak-operator:26.5.3"] = []byte(`{}`)
+
+	c := client(srv)
+	c.Token = testToken
+	if _, err := c.Resolve(context.Background(), "registry.example", "keycloak/keycloak-operator", "26.5.3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.scopes) != 0 {
+		t.Errorf("fetched tokens %v with a static token", reg.scopes)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	c := &Client{Endpoints: map[string]string{"quay.io": "http://localhost:5000/"}}
+	tests := map[string]string{
+		"quay.io":        "http://localhost:5000",
+		"ghcr.io":        "https://ghcr.io",
+		"docker.io":      "https://registry-1.docker.io",
+		"":               "https://registry-1.docker.io",
+		"localhost:5000": "https://localhost:5000",
+	}
+	for registry, want := range tests {
+		if got := c.BaseURL(registry); got != want {
+			t.Errorf("BaseURL(%q) = %s, want %s", registry, got, want)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example/token",service="registry.example",scope="repository:a/b:pull"`)
+	if scheme != "Bearer" {
+		t.Errorf("scheme = %q", scheme)
+	}
+	want := map[string]string{"realm": "https://auth.example/token", "service": "registry.example", "scope": "repository:a/b:pull"}
+	if fmt.Sprint(params) != fmt.Sprint(want) {
+		t.Errorf("params = %v, want %v", params, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	u, _ := url.Parse("https://registry.example/v2/a/blobs/uploads/1?state=secret&digest=sha256:00")
+	if got := redact(u); got != "https://registry.example/v2/a/blobs/uploads/1" {
+		t.Errorf("redact = %s", got)
+	}
+}
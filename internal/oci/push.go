@@ -0,0 +1,136 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ImageManifestMediaType is the media type of an OCI image manifest.
+const ImageManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+// Descriptor is an OCI content descriptor.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// NewDescriptor describes data of the given media type.
+func NewDescriptor(mediaType string, data []byte) Descriptor {
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+		Size:      int64(len(data)),
+	}
+}
+
+// PushBlob uploads data to repository, unless the registry already has it,
+// and returns its descriptor.
+func (c *Client) PushBlob(ctx context.Context, registry, repository, mediaType string, data []byte) (Descriptor, error) {
+	desc := NewDescriptor(mediaType, data)
+	base := c.BaseURL(registry)
+	scope := "repository:" + repository + ":pull,push"
+
+	blobURL := fmt.Sprintf("%s/v2/%s/blobs/%s", base, repository, desc.Digest)
+	resp, err := c.Do(func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodHead, blobURL, nil)
+	}, scope)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return desc, nil
+	}
+
+	// Monolithic upload: open a session, then PUT the whole blob to it.
+	uploadURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/", base, repository)
+	resp, err = c.Do(func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, nil)
+	}, scope)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return Descriptor{}, fmt.Errorf("POST %s: %s", uploadURL, resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("POST %s: invalid Location: %w", uploadURL, err)
+	}
+	q := location.Query()
+	q.Set("digest", desc.Digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.Do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, location.String(), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	}, scope)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return Descriptor{}, fmt.Errorf("PUT %s: %s: %s", redact(location), resp.Status, readError(resp.Body))
+	}
+	return desc, nil
+}
+
+// PushManifest uploads a manifest under reference, usually a tag, and returns
+// its digest.
+func (c *Client) PushManifest(ctx context.Context, registry, repository, reference string, m Manifest) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.BaseURL(registry), repository, reference)
+	resp, err := c.Do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, manifestURL, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", m.MediaType)
+		return req, nil
+	}, "repository:"+repository+":pull,push")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("PUT %s: %s: %s", manifestURL, resp.Status, readError(resp.Body))
+	}
+	return NewDescriptor(m.MediaType, data).Digest, nil
+}
+
+// redact drops the query of an upload URL, which may carry a session state.
+func redact(u *url.URL) string {
+	c := *u
+	c.RawQuery = ""
+	return c.String()
+}
+
+func readError(r io.Reader) string {
+	body, _ := io.ReadAll(io.LimitReader(r, 512))
+	return string(bytes.TrimSpace(body))
+}
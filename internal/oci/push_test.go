@@ -0,0 +1,84 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPushBlob(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	c := client(srv)
+	data := []byte("chart archive")
+
+	desc, err := c.PushBlob(context.Background(), "registry.example", "charts/keycloak-operator", "application/octet-stream", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewDescriptor("application/octet-stream", data)
+	if desc.Digest != want.Digest || desc.Size != int64(len(data)) || desc.MediaType != want.MediaType {
+		t.Errorf("PushBlob = %+v, want %+v", desc, want)
+	}
+	if got := reg.blobs["charts/keycloak-operator@"+desc.Digest]; string(got) != string(data) {
+		t.Errorf("registry has %q, want %q", got, data)
+	}
+
+	// A blob the registry already has is not uploaded again.
+	if _, err := c.PushBlob(context.Background(), "registry.example", "charts/keycloak-operator", "application/octet-stream", data); err != nil {
+		t.Fatal(err)
+	}
+	if reg.uploads != 1 {
+		t.Errorf("uploads = %d, want 1", reg.uploads)
+	}
+}
+
+func TestPushManifest(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	reg.username, reg.password = "user", "secret"
+	c := client(srv)
+	c.Username, c.Password = "user", "secret"
+
+	config, err := c.PushBlob(context.Background(), "registry.example", "charts/keycloak-operator", "application/vnd.cncf.helm.config.v1+json", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{SchemaVersion: 2, MediaType: ImageManifestMediaType, Config: config, Layers: []Descriptor{config}}
+	digest, err := c.PushManifest(context.Background(), "registry.example", "charts/keycloak-operator", "0.6.0", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := reg.manifests["charts/keycloak-operator:0.6.0"]
+	if want := fmt.Sprintf("sha256:%x", sha256.Sum256(stored)); digest != want {
+		t.Errorf("PushManifest = %s, want the digest of the stored manifest %s", digest, want)
+	}
+	var got Manifest
+	if err := json.Unmarshal(stored, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Config.Digest != config.Digest || len(got.Layers) != 1 {
+		t.Errorf("stored manifest = %+v", got)
+	}
+	for _, scope := range reg.scopes {
+		if scope != "repository:charts/keycloak-operator:pull,push" {
+			t.Errorf("token scope = %q, want pull,push", scope)
+		}
+	}
+	if digest2, err := c.Resolve(context.Background(), "registry.example", "charts/keycloak-operator", "0.6.0"); err != nil || digest2 != digest {
+		t.Errorf("Resolve after push = %s, %v; want %s", digest2, err, digest)
+	}
+}
+
+func TestPushManifestRejected(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	reg.username, reg.password = "user", "secret"
+	c := client(srv)
+
+	_, err := c.PushManifest(context.Background(), "registry.example", "charts/keycloak-operator", "0.6.0", Manifest{SchemaVersion: 2})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("PushManifest without credentials: error = %v, want 401", err)
+	}
+}
@@ -0,0 +1,100 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Range is a set of versions such as ">=25.0.0 <26.0.0 || 26.1.2":
+// comparisons separated by spaces must all hold, and "||" separates
+// alternatives. An operator may be followed by a space, as in ">= 25.0.0". A
+// bare version matches only itself. Pre-releases match only when named
+// exactly.
+type Range [][]constraint
+
+type constraint struct {
+	op string
+	v  Version
+}
+
+var operators = []string{">=", "<=", ">", "<", "="}
+
+// ParseRange parses a range.
+func ParseRange(s string) (Range, error) {
+	var r Range
+	for _, alt := range strings.Split(s, "||") {
+		var all []constraint
+		fields := strings.Fields(alt)
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			if isOperator(f) && i+1 < len(fields) {
+				i++
+				f += fields[i]
+			}
+			c := constraint{op: "="}
+			for _, op := range operators {
+				if rest, ok := strings.CutPrefix(f, op); ok {
+					c.op, f = op, rest
+					break
+				}
+			}
+			v, err := Parse(f)
+			if err != nil {
+				return nil, fmt.Errorf("range %q: %w", s, err)
+			}
+			c.v = v
+			all = append(all, c)
+		}
+		if len(all) == 0 {
+			return nil, fmt.Errorf("range %q: empty alternative", s)
+		}
+		r = append(r, all)
+	}
+	return r, nil
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether v is in the range.
+func (r Range) Contains(v Version) bool {
+	for _, all := range r {
+		if matchesAll(all, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(all []constraint, v Version) bool {
+	for _, c := range all {
+		if v.Pre != "" && (c.op != "=" || c.v.Pre == "") {
+			return false
+		}
+		if !c.matches(v.Compare(c.v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether a comparison result d satisfies the operator.
+func (c constraint) matches(d int) bool {
+	switch c.op {
+	case ">=":
+		return d >= 0
+	case "<=":
+		return d <= 0
+	case ">":
+		return d > 0
+	case "<":
+		return d < 0
+	}
+	return d == 0
+}
@@ -0,0 +1,53 @@
+package semver
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		rng string
+		in  []string
+		out []string
+	}{
+		{">=25.0.0 <26.0.0", []string{"25.0.0", "25.0.6", "25.9.9"}, []string{"24.0.9", "26.0.0", "25.0.1-rc.1"}},
+		{">= 25.0.0 < 26.0.0", []string{"25.0.0", "25.0.6"}, []string{"24.0.9", "26.0.0"}},
+		{">=26.1.0 || 25.0.6", []string{"25.0.6", "26.1.0", "27.0.0"}, []string{"25.0.5", "26.0.7"}},
+		{"26.0", []string{"26.0.0"}, []string{"26.0.1"}},
+		{"=26.1.0-rc.1", []string{"26.1.0-rc.1"}, []string{"26.1.0", "26.1.0-rc.2"}},
+		{">26.0.0", []string{"26.0.1", "v26.1.0"}, []string{"26.0.0", "26.1.0-rc.1"}},
+		{"<=26.0.0", []string{"26.0.0", "26.0.0+build.1"}, []string{"26.0.1"}},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.rng)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %v", tt.rng, err)
+			continue
+		}
+		for _, s := range tt.in {
+			if !r.Contains(mustParse(t, s)) {
+				t.Errorf("%q does not contain %s", tt.rng, s)
+			}
+		}
+		for _, s := range tt.out {
+			if r.Contains(mustParse(t, s)) {
+				t.Errorf("%q contains %s", tt.rng, s)
+			}
+		}
+	}
+}
+
+func TestParseRangeInvalid(t *testing.T) {
+	for _, s := range []string{"", ">=25.0.0 ||", "~25.0", ">=", ">= x.y.z", "25.0.0.1"} {
+		if _, err := ParseRange(s); err == nil {
+			t.Errorf("ParseRange(%q) succeeded", s)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}